@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	checkExitInvalid = 1
+	checkExitConnect = 2
+)
+
+var (
+	checkCommand = kingpin.Command("check-config", "Check configuration file, exit code 0 = valid, 1 = invalid, 2 = connect failed.")
+	checkConnect = checkCommand.Flag("connect", "Connect to NUT server, login and check that UPS exists").Default("false").Bool()
+
+	yamlLineRegex = regexp.MustCompile(`^line (\d+): (.*)$`)
+	keyIndexRegex = regexp.MustCompile(`^(.*)\[(\d+)\]$`)
+)
+
+type configDiagnostic struct {
+	Line     int
+	Column   int
+	Severity string
+	Message  string
+}
+
+func (d configDiagnostic) format(filename string) string {
+	if d.Line > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s: %s", filename, d.Line, d.Column, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", filename, d.Severity, d.Message)
+}
+
+// unmarshalStrict decode YAML, unknown keys are errors and empty content keep values in out
+func unmarshalStrict(content []byte, out interface{}) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(out); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// parseConfigContent decode configuration, type errors are returned as errors and unknown keys as warnings
+func parseConfigContent(content []byte, c *configData) (*yaml.Node, []configDiagnostic, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, nil, err
+	}
+	if len(root.Content) == 0 {
+		return &root, nil, nil
+	}
+	var diagnostics []configDiagnostic
+	if err := root.Decode(c); err != nil {
+		var typeError *yaml.TypeError
+		if !errors.As(err, &typeError) {
+			return nil, nil, err
+		}
+		for _, message := range typeError.Errors {
+			diagnostic := configDiagnostic{Severity: "error", Message: message}
+			if found := yamlLineRegex.FindStringSubmatch(message); found != nil {
+				diagnostic.Line, _ = strconv.Atoi(found[1])
+				diagnostic.Column = 1
+				diagnostic.Message = found[2]
+			}
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+	diagnostics = append(diagnostics, unknownKeys(root.Content[0], reflect.TypeOf(configData{}), "")...)
+	return &root, diagnostics, nil
+}
+
+// unknownKeys compare keys in mapping with YAML tags of structure
+func unknownKeys(node *yaml.Node, t reflect.Type, path string) []configDiagnostic {
+	var diagnostics []configDiagnostic
+	switch {
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			diagnostics = append(diagnostics, unknownKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if len(key) > 0 {
+				fields[key] = t.Field(i).Type
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			name := key.Value
+			if len(path) > 0 {
+				name = path + "." + key.Value
+			}
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				diagnostics = append(diagnostics, configDiagnostic{key.Line, key.Column, "warning", "unknown key \"" + name + "\""})
+				continue
+			}
+			diagnostics = append(diagnostics, unknownKeys(node.Content[i+1], fieldType, name)...)
+		}
+	}
+	return diagnostics
+}
+
+// findKey return node for key path like "mqtt" or "webhooks[0]", nil when key isn't in file
+func findKey(root *yaml.Node, path string) *yaml.Node {
+	if root == nil || len(root.Content) == 0 {
+		return nil
+	}
+	node := root.Content[0]
+	for _, part := range strings.Split(path, ".") {
+		index := -1
+		if found := keyIndexRegex.FindStringSubmatch(part); found != nil {
+			part = found[1]
+			index, _ = strconv.Atoi(found[2])
+		}
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == part {
+				next = node.Content[i+1]
+				if index < 0 {
+					next = node.Content[i]
+				}
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		if index >= 0 {
+			if next.Kind != yaml.SequenceNode || index >= len(next.Content) {
+				return nil
+			}
+			next = next.Content[index]
+		}
+		node = next
+	}
+	return node
+}
+
+// validationDiagnostics return validation errors with position of key in file,
+// value set by environment variable or flag has source instead of position
+func validationDiagnostics(c *configData, root *yaml.Node) []configDiagnostic {
+	var diagnostics []configDiagnostic
+	for _, e := range c.validateAll() {
+		diagnostic := configDiagnostic{Severity: "error", Message: e.Key + ": " + e.Err.Error()}
+		if source, ok := c.overrideSource(e.Key); ok {
+			diagnostic.Message += " (value from " + source + ")"
+		} else if node := findKey(root, e.Key); node != nil {
+			diagnostic.Line = node.Line
+			diagnostic.Column = node.Column
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	return diagnostics
+}
+
+// checkConfig print all problems in configuration file and return exit code
+func checkConfig(filename string) int {
+	c := newConfig()
+	var root *yaml.Node
+	var diagnostics []configDiagnostic
+	if fileExists(filename) {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Printf("%s: error: %s\n", filename, err)
+			return checkExitInvalid
+		}
+		root, diagnostics, err = parseConfigContent(content, c)
+		if err != nil {
+			fmt.Printf("%s: error: %s\n", filename, err)
+			return checkExitInvalid
+		}
+	} else {
+		fmt.Printf("%s: warning: file not found, only defaults, environment and flags are used\n", filename)
+	}
+	if err := c.applyOverrides(); err != nil {
+		diagnostics = append(diagnostics, configDiagnostic{Severity: "error", Message: err.Error()})
+	}
+	diagnostics = append(diagnostics, validationDiagnostics(c, root)...)
+	errorCount := 0
+	for _, diagnostic := range diagnostics {
+		fmt.Println(diagnostic.format(filename))
+		if diagnostic.Severity == "error" {
+			errorCount++
+		}
+	}
+	if errorCount > 0 {
+		fmt.Printf("%s: configuration is invalid (%d errors, %d warnings)\n", filename, errorCount, len(diagnostics)-errorCount)
+		return checkExitInvalid
+	}
+	fmt.Printf("%s: configuration is valid (%d warnings)\n", filename, len(diagnostics))
+	if *checkConnect {
+		if err := checkConnection(c); err != nil {
+			fmt.Printf("%s: error: connect to NUT server %s failed: %s\n", filename, c.getServer(), err)
+			return checkExitConnect
+		}
+		fmt.Printf("%s: NUT server %s is available and UPS [%s] exists\n", filename, c.getServer(), c.UpsName)
+	}
+	return 0
+}
+
+// checkConnection login to NUT server and check that UPS is in LIST UPS
+func checkConnection(c *configData) error {
+	conn := newConnection(c.getServer(), c.loginUser(), c.Password, c.UpsName)
+	if err := conn.open(); err != nil {
+		return err
+	}
+	defer conn.close()
+	lines, err := conn.commandListRaw("LIST UPS")
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		s := strings.SplitN(line, " ", 3)
+		if len(s) > 1 && s[1] == c.UpsName {
+			return nil
+		}
+	}
+	return errors.New("UPS [" + c.UpsName + "] doesn't exist on server")
+}
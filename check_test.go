@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const validTestConfig = "server: 127.0.0.1\nuser: monitor\npassword: secret\n"
+
+func TestLoadFileUnknownKey(t *testing.T) {
+	c := newConfig()
+	err := c.loadFile(writeTestFile(t, "nut.yml", validTestConfig+"refersh: 30\n"))
+	if err == nil || !strings.Contains(err.Error(), `4:1: error: unknown key "refersh"`) {
+		t.Fatalf("expected error for unknown key get %v", err)
+	}
+	c = newConfig()
+	err = c.loadFile(writeTestFile(t, "nut.json", `{"server": "127.0.0.1", "user": "monitor", "password": "secret", "refersh": 30}`))
+	if err == nil || !strings.Contains(err.Error(), "refersh") {
+		t.Fatalf("expected error for unknown key in JSON get %v", err)
+	}
+}
+
+func TestCheckConfigUnknownKeyWarning(t *testing.T) {
+	c := newConfig()
+	_, diagnostics, err := parseConfigContent([]byte(validTestConfig+"mqtt:\n  brokr: tcp://localhost:1883\n"), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 1 || diagnostics[0].Severity != "warning" || diagnostics[0].Line != 5 {
+		t.Fatalf("expected one warning on line 5 get %+v", diagnostics)
+	}
+}
+
+func TestValidateAllReturnAllErrors(t *testing.T) {
+	c := newConfig()
+	content := validTestConfig + `
+webhooks:
+  - url: ""
+    events: [power]
+    retries: 20
+mqtt:
+  broker: mqtt://localhost
+  qos: 3
+  topic: "ups/#"
+`
+	if _, _, err := parseConfigContent([]byte(content), c); err != nil {
+		t.Fatal(err)
+	}
+	count := map[string]int{}
+	for _, e := range c.validateAll() {
+		count[e.Key]++
+	}
+	if count["webhooks[0]"] != 3 || count["mqtt"] != 3 {
+		t.Fatalf("expected 3 errors for webhook and MQTT get %v", count)
+	}
+}
+
+func TestValidationDiagnosticsSource(t *testing.T) {
+	if err := os.Setenv("NUT_EXPORTER_MQTT_QOS", "5"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("NUT_EXPORTER_MQTT_QOS")
+	c := newConfig()
+	root, _, err := parseConfigContent([]byte(validTestConfig+"refresh: 1\nmqtt:\n  broker: tcp://localhost:1883\n"), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.applyOverrides(); err != nil {
+		t.Fatal(err)
+	}
+	diagnostics := map[string]configDiagnostic{}
+	for _, diagnostic := range validationDiagnostics(c, root) {
+		diagnostics[strings.SplitN(diagnostic.Message, ":", 2)[0]] = diagnostic
+	}
+	if d := diagnostics["refresh"]; d.Line != 4 {
+		t.Errorf("value from file must have line 4 get %+v", d)
+	}
+	if d := diagnostics["mqtt"]; d.Line != 0 || !strings.Contains(d.Message, "environment variable NUT_EXPORTER_MQTT_QOS") {
+		t.Errorf("value from environment must have source without line get %+v", d)
+	}
+}
@@ -1,43 +1,73 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"gopkg.in/alecthomas/kingpin.v2"
-	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type configData struct {
-	Server   string `yaml:"server" json:"server"`
-	UpsName  string `yaml:"upsName" json:"upsName"`
-	User     string `yaml:"user" json:"user"`
-	Password string `yaml:"password" json:"password"`
-	Port     uint16 `yaml:"port" json:"port"`
-	Refresh  int    `yaml:"refresh" json:"refresh"`
+	Server         string            `yaml:"server" json:"server"`
+	UpsName        string            `yaml:"upsName" json:"upsName"`
+	User           string            `yaml:"user" json:"user"`
+	Password       string            `yaml:"password" json:"password"`
+	PasswordFile   string            `yaml:"passwordFile" json:"passwordFile"`
+	Anonymous      bool              `yaml:"anonymous" json:"anonymous"`
+	Port           uint16            `yaml:"port" json:"port"`
+	Refresh        int               `yaml:"refresh" json:"refresh"`
+	EventLog       string            `yaml:"eventLog" json:"eventLog"`
+	EventLogSize   int               `yaml:"eventLogMaxSize" json:"eventLogMaxSize"`
+	EventLogAge    int               `yaml:"eventLogMaxAge" json:"eventLogMaxAge"`
+	Webhooks       []webhookConfig   `yaml:"webhooks" json:"webhooks"`
+	Hooks          []hookConfig      `yaml:"hooks" json:"hooks"`
+	Mqtt           mqttConfig        `yaml:"mqtt" json:"mqtt"`
+	Influx         influxConfig      `yaml:"influx" json:"influx"`
+	RemoteWrite    remoteWriteConfig `yaml:"remoteWrite" json:"remoteWrite"`
+	Otlp           otlpConfig        `yaml:"otlp" json:"otlp"`
+	ReadyFailures  int               `yaml:"readyFailures" json:"readyFailures"`
+	MetricsMapping string            `yaml:"metricsMapping" json:"metricsMapping"`
+	Filters        filterConfig      `yaml:"filters" json:"filters"`
+	sources        map[string]string // key path -> environment variable or flag which override value from file
 }
 
 var (
-	showConfig    = kingpin.Flag("config.show", "Show actual configuration and ends").Default("false").Bool()
-	configFile    = kingpin.Flag("config.file", "Configuration file default is \"nut.yml\".").PlaceHolder("cfg.yml").Default("nut.yml").String()
-	server        = kingpin.Flag("nut.server", "NUT server FQDn or IP address").PlaceHolder("server").Default("").String()
-	user          = kingpin.Flag("nut.user", "NUT user for read data").PlaceHolder("user").Default("").String()
-	pwd           = kingpin.Flag("nut.pwd", "NUT user password").PlaceHolder("pwd").Default("").String()
-	upsName       = kingpin.Flag("nut.ups", "name of UPS on NUT server").PlaceHolder("ups").Default("ups").String()
-	listenAddress = kingpin.Flag("web.listen-address", "Address on which to expose metrics and web interface.").Default(":8100").String()
-	config        = &configData{
-		Server:   "",
-		UpsName:  "ups",
-		User:     "",
-		Password: "",
-		Port:     3493,
-		Refresh:  10,
-	}
+	showConfig     = kingpin.Flag("config.show", "Show actual configuration and ends").Default("false").Bool()
+	configFile     = kingpin.Flag("config.file", "Configuration file default is \"nut.yml\".").PlaceHolder("cfg.yml").Default("nut.yml").String()
+	server         = kingpin.Flag("nut.server", "NUT server FQDn or IP address").PlaceHolder("server").Default("").String()
+	user           = kingpin.Flag("nut.user", "NUT user for read data").PlaceHolder("user").Default("").String()
+	pwd            = kingpin.Flag("nut.pwd", "NUT user password").PlaceHolder("pwd").Default("").String()
+	pwdFile        = kingpin.Flag("nut.pwd-file", "File with NUT user password").PlaceHolder("file").Default("").String()
+	anonymous      = kingpin.Flag("nut.anonymous", "Read data without login to NUT server, user and password are not required").Default("false").Bool()
+	upsName        = kingpin.Flag("nut.ups", "name of UPS on NUT server (default \"ups\")").PlaceHolder("ups").Default("").String()
+	eventLogFile   = kingpin.Flag("events.file", "File for persistent log of UPS events (JSON lines), empty disable log").PlaceHolder("events.log").Default("").String()
+	metricsMapping = kingpin.Flag("metrics.mapping", "File with mapping of NUT variables to metrics, extend or override built-in mapping").PlaceHolder("metrics.yml").Default("").String()
+	listenAddress  = kingpin.Flag("web.listen-address", "Address on which to expose metrics and web interface.").Default(":8100").String()
+	config         = newConfig()
 )
 
+func newConfig() *configData {
+	return &configData{
+		Server:        "",
+		UpsName:       "ups",
+		User:          "",
+		Password:      "",
+		Port:          3493,
+		Refresh:       10,
+		ReadyFailures: 3,
+		EventLogSize:  10,
+		sources:       map[string]string{},
+	}
+}
+
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	if os.IsNotExist(err) {
@@ -46,64 +76,267 @@ func fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
-func (c *configData) validate() error {
-	match, err := regexp.MatchString("^(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])$", c.Server)
-	if !match || err != nil {
-		match, err = regexp.MatchString("^(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\\-]*[a-zA-Z0-9])\\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\\-]*[A-Za-z0-9])$", c.Server)
-		if !match || err != nil {
-			return errors.New("NUT server address isn't valid FQDN or IP address")
+var (
+	hostnameRegex = regexp.MustCompile(`^(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\-]*[a-zA-Z0-9])\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\-]*[A-Za-z0-9])\.?$`)
+	srvRegex      = regexp.MustCompile(`^_[a-zA-Z0-9\-]+\._(tcp|udp)\.`)
+	nameRegex     = regexp.MustCompile(`[^a-zA-Z0-9._\-]`)
+)
+
+// kinds of NUT server address
+const (
+	serverHost = "host"
+	serverUnix = "unix"
+	serverSRV  = "srv"
+)
+
+// serverKind classify NUT server address, validation, dial and diagnose use same rule
+func serverKind(server string) string {
+	switch {
+	case strings.HasPrefix(server, "unix:"):
+		return serverUnix
+	case srvRegex.MatchString(server):
+		return serverSRV
+	}
+	return serverHost
+}
+
+// unixSocketPath return path from unix:/path or unix:///path
+func unixSocketPath(server string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(server, "unix:"), "//")
+}
+
+// validateServer accept IP address (IPv6 also in brackets), hostname, SRV name (_nut._tcp.example.com)
+// or unix socket (unix:/path/to/socket)
+func validateServer(server string) error {
+	switch {
+	case serverKind(server) == serverUnix:
+		if len(unixSocketPath(server)) == 0 {
+			return errors.New("NUT server unix socket path must be defined")
 		}
+		return nil
+	case serverKind(server) == serverSRV:
+		if !hostnameRegex.MatchString(srvRegex.ReplaceAllString(server, "")) {
+			return errors.New("NUT server SRV name isn't valid")
+		}
+		return nil
+	case strings.HasPrefix(server, "[") && strings.HasSuffix(server, "]"):
+		ip := net.ParseIP(strings.Trim(server, "[]"))
+		if ip == nil || ip.To4() != nil {
+			return errors.New("NUT server address in brackets isn't valid IPv6 address")
+		}
+		return nil
+	case net.ParseIP(server) != nil, hostnameRegex.MatchString(server):
+		return nil
 	}
-	if len(c.User) < 1 {
-		return errors.New("NUT User must be defined")
+	return errors.New("NUT server address isn't valid FQDN or IP address")
+}
+
+type configError struct {
+	Key string
+	Err error
+}
+
+// validateAll return all problems in configuration, key is path to wrong value in configuration file
+func (c *configData) validateAll() []configError {
+	var errs []configError
+	add := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, configError{key, err})
+		}
 	}
-	if len(c.Password) < 1 {
-		return errors.New("NUT User password must be defined")
+	addAll := func(key string, list []error) {
+		for _, err := range list {
+			add(key, err)
+		}
+	}
+	add("server", validateServer(c.Server))
+	if len(c.User) < 1 && !c.Anonymous {
+		add("user", errors.New("NUT User must be defined"))
+	}
+	if len(c.Password) < 1 && !c.Anonymous {
+		add("password", errors.New("NUT User password must be defined"))
 	}
 	if len(c.UpsName) < 1 {
-		return errors.New("UPS name must be defined")
+		add("upsName", errors.New("UPS name must be defined"))
 	}
-	if c.Port < 1024 || c.Port > 65535 {
-		return errors.New("defined port not valid")
+	if c.Port < 1 {
+		add("port", errors.New("defined port not valid"))
 	}
 	if c.Refresh < 5 || c.Refresh > 300 {
-		return errors.New("refresh time is out of range (5-300 sec)")
+		add("refresh", errors.New("refresh time is out of range (5-300 sec)"))
+	}
+	if c.ReadyFailures < 1 || c.ReadyFailures > 100 {
+		add("readyFailures", errors.New("ready failures threshold is out of range (1-100)"))
+	}
+	if c.EventLogSize < 0 || c.EventLogSize > 1024 {
+		add("eventLogMaxSize", errors.New("event log max size is out of range (0-1024 MB)"))
 	}
+	if c.EventLogAge < 0 || c.EventLogAge > 3650 {
+		add("eventLogMaxAge", errors.New("event log max age is out of range (0-3650 days)"))
+	}
+	for i, hook := range c.Webhooks {
+		addAll(fmt.Sprintf("webhooks[%d]", i), hook.validate())
+	}
+	for i, hook := range c.Hooks {
+		addAll(fmt.Sprintf("hooks[%d]", i), hook.validate())
+	}
+	addAll("mqtt", c.Mqtt.validate())
+	addAll("influx", c.Influx.validate())
+	addAll("remoteWrite", c.RemoteWrite.validate())
+	addAll("otlp", c.Otlp.validate())
+	addAll("filters", c.Filters.validate())
+	if _, err := loadMetricMappings(c.MetricsMapping); err != nil {
+		add("metricsMapping", err)
+	}
+	return errs
+}
 
+func (c *configData) validate() error {
+	if errs := c.validateAll(); len(errs) > 0 {
+		return errs[0].Err
+	}
 	return nil
 }
 
 func (c *configData) loadFile(filename string) error {
+	return c.load(filename, false)
+}
+
+// load read configuration file and apply overrides, anonymous mode is forced for commands which never login
+func (c *configData) load(filename string, anonymous bool) error {
 	if fileExists(filename) {
 		content, err := ioutil.ReadFile(filename)
 		if err != nil {
 			return err
 		}
-		err = yaml.UnmarshalStrict(content, c)
+		_, diagnostics, err := parseConfigContent(content, c)
 		if err != nil {
-			err = json.Unmarshal(content, c)
-			if err != nil {
+			decoder := json.NewDecoder(bytes.NewReader(content))
+			decoder.DisallowUnknownFields()
+			if err = decoder.Decode(c); err != nil {
 				return err
 			}
 		}
+		// unknown keys are only warnings in check-config, exporter doesn't start with them
+		var problems []string
+		for _, diagnostic := range diagnostics {
+			diagnostic.Severity = "error"
+			problems = append(problems, diagnostic.format(filename))
+		}
+		if len(problems) > 0 {
+			return errors.New(strings.Join(problems, "; "))
+		}
+	}
+	if err := c.applyOverrides(); err != nil {
+		return err
+	}
+	if anonymous {
+		c.Anonymous = true
+	}
+	return c.validate()
+}
+
+// applyOverrides apply environment variables, flags and password file over values from configuration file
+func (c *configData) applyOverrides() error {
+	if err := c.loadEnv(); err != nil {
+		return err
 	}
 	if len(*server) > 0 {
 		c.Server = *server
+		c.sources["server"] = "flag --nut.server"
 	}
 	if len(*user) > 0 {
 		c.User = *user
+		c.sources["user"] = "flag --nut.user"
 	}
-	if len(*pwd) > 0 {
-		c.Password = *pwd
+	if len(*pwdFile) > 0 {
+		c.PasswordFile = *pwdFile
+		c.sources["passwordFile"] = "flag --nut.pwd-file"
 	}
 	if len(*upsName) > 0 {
 		c.UpsName = *upsName
+		c.sources["upsName"] = "flag --nut.ups"
 	}
-	return c.validate()
+	if *anonymous {
+		c.Anonymous = true
+		c.sources["anonymous"] = "flag --nut.anonymous"
+	}
+	if len(*eventLogFile) > 0 {
+		c.EventLog = *eventLogFile
+		c.sources["eventLog"] = "flag --events.file"
+	}
+	if len(*metricsMapping) > 0 {
+		c.MetricsMapping = *metricsMapping
+		c.sources["metricsMapping"] = "flag --metrics.mapping"
+	}
+	if len(*pwd) > 0 {
+		c.Password = *pwd
+		c.sources["password"] = "flag --nut.pwd"
+	}
+	// password file is used only when it is from same or higher source than password
+	if len(c.PasswordFile) > 0 && c.sourceRank("passwordFile") >= c.sourceRank("password") {
+		content, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return err
+		}
+		c.Password = strings.TrimRight(string(content), "\r\n")
+		c.sources["password"] = "password file " + c.PasswordFile
+	}
+	return nil
+}
+
+// sourceRank return precedence of source of value, flag > environment variable > configuration file
+func (c *configData) sourceRank(key string) int {
+	source := c.sources[key]
+	switch {
+	case strings.HasPrefix(source, "flag "):
+		return 2
+	case strings.HasPrefix(source, "environment "):
+		return 1
+	}
+	return 0
+}
+
+// overrideSource return environment variable or flag which set value for key path (mqtt, webhooks[0], server)
+func (c *configData) overrideSource(key string) (string, bool) {
+	var keys []string
+	for overridden := range c.sources {
+		keys = append(keys, overridden)
+	}
+	sort.Strings(keys)
+	for _, overridden := range keys {
+		if key == overridden || strings.HasPrefix(overridden, key+".") ||
+			strings.HasPrefix(key, overridden+".") || strings.HasPrefix(key, overridden+"[") {
+			return c.sources[overridden], true
+		}
+	}
+	return "", false
+}
+
+// loginUser return user for login, anonymous mode use empty user
+func (c *configData) loginUser() string {
+	if c.Anonymous {
+		return ""
+	}
+	return c.User
 }
 
+// getServer return address for dial, unix socket and SRV name are returned without port
 func (c *configData) getServer() string {
-	return fmt.Sprintf("%s:%d", c.Server, c.Port)
+	if serverKind(c.Server) != serverHost {
+		return c.Server
+	}
+	return net.JoinHostPort(strings.Trim(c.Server, "[]"), strconv.Itoa(int(c.Port)))
+}
+
+// serverName return server usable in MQTT topic, MQTT client ID and InfluxDB tag,
+// unix:/run/nut/upsd.sock is unix_run_nut_upsd.sock and [fd00::5] is fd00__5
+func (c *configData) serverName() string {
+	server := c.Server
+	if serverKind(server) == serverUnix {
+		server = "unix_" + strings.TrimLeft(unixSocketPath(server), "/")
+	}
+	return nameRegex.ReplaceAllString(strings.Trim(server, "[]"), "_")
 }
 
 func (c *configData) print() string {
@@ -113,8 +346,19 @@ func (c *configData) print() string {
 	}
 	a := fmt.Sprintf("\r\n%s\r\nActual configuration:\r\n", applicationName)
 	a = fmt.Sprintf("%sUPS name:     [%s]\r\n", a, c.UpsName)
-	a = fmt.Sprintf("%sNUT Server :  [%s:%d]\r\n", a, c.Server, c.Port)
+	a = fmt.Sprintf("%sNUT Server :  [%s]\r\n", a, c.getServer())
 	a = fmt.Sprintf("%sUser:         [%s]\r\n", a, c.User)
+	a = fmt.Sprintf("%sAnonymous:    [%t]\r\n", a, c.Anonymous)
 	a = fmt.Sprintf("%sPassword:     [%s]\r\n", a, p)
+	a = fmt.Sprintf("%sPassword file:[%s]\r\n", a, c.PasswordFile)
+	a = fmt.Sprintf("%sEvent log:    [%s] max size [%d MB] max age [%d days]\r\n", a, c.EventLog, c.EventLogSize, c.EventLogAge)
+	a = fmt.Sprintf("%sWebhooks:     [%d]\r\n", a, len(c.Webhooks))
+	a = fmt.Sprintf("%sHooks:        [%d]\r\n", a, len(c.Hooks))
+	a = fmt.Sprintf("%sMQTT broker:  [%s]\r\n", a, c.Mqtt.Broker)
+	a = fmt.Sprintf("%sInfluxDB:     [%s]\r\n", a, c.Influx.URL)
+	a = fmt.Sprintf("%sRemote write: [%s]\r\n", a, c.RemoteWrite.URL)
+	a = fmt.Sprintf("%sOTLP:         [%s]\r\n", a, c.Otlp.Endpoint)
+	a = fmt.Sprintf("%sReady limit:  [%d]\r\n", a, c.ReadyFailures)
+	a = fmt.Sprintf("%sMetrics map:  [%s]\r\n", a, c.MetricsMapping)
 	return a
 }
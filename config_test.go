@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPasswordPrecedence(t *testing.T) {
+	passwordFile := writeTestFile(t, "password", "from-file\n")
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		flag     string
+		flagFile string
+		expected string
+	}{
+		{name: "configuration file", file: "password: config\n", expected: "config"},
+		{name: "password file replaces password in same file", file: "password: config\npasswordFile: " + passwordFile + "\n", expected: "from-file"},
+		{name: "environment over configuration file", file: "password: config\n", env: map[string]string{"NUT_EXPORTER_PASSWORD": "env"}, expected: "env"},
+		{name: "environment over password file from configuration", file: "passwordFile: " + passwordFile + "\n", env: map[string]string{"NUT_EXPORTER_PASSWORD": "env"}, expected: "env"},
+		{name: "password file from environment", file: "password: config\n", env: map[string]string{"NUT_EXPORTER_PASSWORD_FILE": passwordFile}, expected: "from-file"},
+		{name: "flag over environment", env: map[string]string{"NUT_EXPORTER_PASSWORD": "env"}, flag: "flag", expected: "flag"},
+		{name: "flag over password file from environment", env: map[string]string{"NUT_EXPORTER_PASSWORD_FILE": passwordFile}, flag: "flag", expected: "flag"},
+		{name: "password file flag over environment", env: map[string]string{"NUT_EXPORTER_PASSWORD": "env"}, flagFile: passwordFile, expected: "from-file"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				if err := os.Setenv(name, value); err != nil {
+					t.Fatal(err)
+				}
+				defer os.Unsetenv(name)
+			}
+			previousPwd, previousPwdFile := *pwd, *pwdFile
+			*pwd, *pwdFile = test.flag, test.flagFile
+			defer func() { *pwd, *pwdFile = previousPwd, previousPwdFile }()
+
+			c := newConfig()
+			if _, _, err := parseConfigContent([]byte(test.file), c); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.applyOverrides(); err != nil {
+				t.Fatal(err)
+			}
+			if c.Password != test.expected {
+				t.Errorf("expected password %q get %q", test.expected, c.Password)
+			}
+		})
+	}
+}
+
+func TestValidateServer(t *testing.T) {
+	tests := []struct {
+		server string
+		valid  bool
+	}{
+		{"192.168.1.10", true},
+		{"nut.example.com", true},
+		{"nut", true},
+		{"fd00::5", true},
+		{"[fd00::5]", true},
+		{"[192.168.1.10]", false},
+		{"[nut.example.com]", false},
+		{"unix:/run/nut/upsd.sock", true},
+		{"unix:///run/nut/upsd.sock", true},
+		{"unix:", false},
+		{"_nut._tcp.example.com", true},
+		{"_nut._tcp.", false},
+		{"nut server", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if err := validateServer(test.server); (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %t get error %v", test.server, test.valid, err)
+		}
+	}
+}
+
+func TestGetServer(t *testing.T) {
+	tests := []struct {
+		server   string
+		kind     string
+		address  string
+		name     string
+		unixPath string
+	}{
+		{server: "192.168.1.10", kind: serverHost, address: "192.168.1.10:3493", name: "192.168.1.10"},
+		{server: "nut.example.com", kind: serverHost, address: "nut.example.com:3493", name: "nut.example.com"},
+		{server: "fd00::5", kind: serverHost, address: "[fd00::5]:3493", name: "fd00__5"},
+		{server: "[fd00::5]", kind: serverHost, address: "[fd00::5]:3493", name: "fd00__5"},
+		{server: "unix:/run/nut/upsd.sock", kind: serverUnix, address: "unix:/run/nut/upsd.sock", name: "unix_run_nut_upsd.sock", unixPath: "/run/nut/upsd.sock"},
+		{server: "unix:///run/nut/upsd.sock", kind: serverUnix, address: "unix:///run/nut/upsd.sock", name: "unix_run_nut_upsd.sock", unixPath: "/run/nut/upsd.sock"},
+		{server: "_nut._tcp.example.com", kind: serverSRV, address: "_nut._tcp.example.com", name: "_nut._tcp.example.com"},
+	}
+	for _, test := range tests {
+		c := newConfig()
+		c.Server = test.server
+		if kind := serverKind(c.Server); kind != test.kind {
+			t.Errorf("%q: expected kind %s get %s", test.server, test.kind, kind)
+		}
+		if address := c.getServer(); address != test.address {
+			t.Errorf("%q: expected address %s get %s", test.server, test.address, address)
+		}
+		if name := c.serverName(); name != test.name {
+			t.Errorf("%q: expected name %s get %s", test.server, test.name, name)
+		}
+		if test.kind == serverUnix && unixSocketPath(c.Server) != test.unixPath {
+			t.Errorf("%q: expected socket path %s get %s", test.server, test.unixPath, unixSocketPath(c.Server))
+		}
+	}
+}
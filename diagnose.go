@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+var (
+	diagnoseCommand  = kingpin.Command("diagnose", "Step by step check of connection to NUT server with timing and server responses.")
+	diagnoseStartTLS = diagnoseCommand.Flag("starttls", "Try STARTTLS before login").Default("false").Bool()
+	diagnoseInsecure = diagnoseCommand.Flag("tls.insecure", "Do not verify NUT server certificate for STARTTLS").Default("false").Bool()
+	diagnoseTimeout  = diagnoseCommand.Flag("timeout", "Timeout for each step").Default("5s").Duration()
+)
+
+// hints for NUT protocol errors https://networkupstools.org/docs/developer-guide.chunked/ar01s09.html#_error_responses
+var diagnoseHints = map[string]string{
+	"ACCESS-DENIED":          "user or password is wrong, or user isn't allowed from this host; check upsd.users",
+	"UNKNOWN-UPS":            "UPS name doesn't exist on server; check ups.conf or list names with 'LIST UPS'",
+	"INVALID-USERNAME":       "user name contains invalid characters",
+	"INVALID-PASSWORD":       "password contains invalid characters",
+	"USERNAME-REQUIRED":      "server requires USERNAME before this command",
+	"PASSWORD-REQUIRED":      "server requires PASSWORD before this command",
+	"ALREADY-LOGGED-IN":      "connection already did LOGIN",
+	"ALREADY-SSL-MODE":       "connection already uses TLS",
+	"FEATURE-NOT-SUPPORTED":  "upsd is built without SSL support",
+	"FEATURE-NOT-CONFIGURED": "upsd has no certificate configured (CERTFILE in upsd.conf)",
+	"DRIVER-NOT-CONNECTED":   "upsd can't talk to UPS driver; start driver with upsdrvctl",
+	"DATA-STALE":             "driver doesn't get fresh data from UPS; check cable and driver log",
+	"UNKNOWN-COMMAND":        "server doesn't know command, it may be older NUT version",
+}
+
+type diagnoseSession struct {
+	conn    net.Conn
+	reader  *textproto.Reader
+	timeout time.Duration
+	failed  bool
+}
+
+func diagnoseHint(err error) string {
+	message := err.Error()
+	for code, hint := range diagnoseHints {
+		if strings.Contains(message, "ERR "+code) {
+			return hint
+		}
+	}
+	switch {
+	case strings.Contains(message, "no such host"):
+		return "server name can't be resolved; check name and DNS configuration"
+	case strings.Contains(message, "connection refused"):
+		return "upsd isn't running or doesn't listen on this address; check LISTEN in upsd.conf"
+	case strings.Contains(message, "timeout"):
+		return "no response in time; check firewall, routing and that port is open"
+	case strings.Contains(message, "no route to host"), strings.Contains(message, "network is unreachable"):
+		return "host isn't reachable from this network"
+	case strings.Contains(message, "certificate"):
+		return "server certificate isn't trusted; use valid certificate or --tls.insecure"
+	case strings.Contains(message, "EOF"), strings.Contains(message, "connection reset"):
+		return "server closed connection; check upsd log, host may be denied by tcp-wrappers or MAXCONN"
+	}
+	return "check upsd log for details"
+}
+
+// step run one diagnostic step and print result with timing
+func (d *diagnoseSession) step(name string, run func() (string, error)) bool {
+	start := time.Now()
+	result, err := run()
+	elapsed := time.Since(start).Round(time.Microsecond)
+	if err != nil {
+		d.failed = true
+		fmt.Printf("[FAIL] %-12s %10s  %s\n", name, elapsed, err)
+		fmt.Printf("       hint: %s\n", diagnoseHint(err))
+		return false
+	}
+	fmt.Printf("[ OK ] %-12s %10s  %s\n", name, elapsed, result)
+	return true
+}
+
+func (d *diagnoseSession) skip(name, reason string) {
+	fmt.Printf("[SKIP] %-12s %10s  %s\n", name, "", reason)
+}
+
+func (d *diagnoseSession) command(input string) (string, error) {
+	_ = d.conn.SetDeadline(time.Now().Add(d.timeout))
+	if _, err := fmt.Fprintf(d.conn, "%s\r\n", input); err != nil {
+		return "", err
+	}
+	line, err := d.reader.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "ERR ") {
+		return "", errors.New("server returned: " + line)
+	}
+	return line, nil
+}
+
+func (d *diagnoseSession) list(input string) (string, error) {
+	line, err := d.command(input)
+	if err != nil {
+		return "", err
+	}
+	count := 0
+	for {
+		line, err = d.reader.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(line, "END ") {
+			return fmt.Sprintf("%d lines, %s", count, line), nil
+		}
+		count++
+	}
+}
+
+// diagnoseAddress resolve address to dial, SRV name is resolved to first target
+func diagnoseAddress(c *configData) (string, string, error) {
+	server := c.getServer()
+	switch serverKind(server) {
+	case serverUnix:
+		return "unix", unixSocketPath(server), nil
+	case serverSRV:
+		targets, err := lookupSRVTargets(server)
+		if err != nil {
+			return "", "", err
+		}
+		return "tcp", targets[0], nil
+	}
+	return "tcp", server, nil
+}
+
+// diagnose run all steps and return exit code
+func diagnose(c *configData) int {
+	d := &diagnoseSession{timeout: *diagnoseTimeout}
+	fmt.Printf("Diagnose connection to NUT server %s for UPS [%s]\n\n", c.getServer(), c.UpsName)
+	network, address := "", ""
+	ok := d.step("DNS", func() (string, error) {
+		var err error
+		network, address, err = diagnoseAddress(c)
+		if err != nil || network == "unix" {
+			return "unix socket " + address, err
+		}
+		host, _, _ := net.SplitHostPort(address)
+		if net.ParseIP(host) != nil {
+			return "IP address " + host, nil
+		}
+		addresses, err := net.LookupHost(host)
+		return host + " -> " + strings.Join(addresses, ", "), err
+	})
+	if ok {
+		ok = d.step("Connect", func() (string, error) {
+			conn, err := net.DialTimeout(network, address, d.timeout)
+			if err != nil {
+				return "", err
+			}
+			d.conn = conn
+			d.reader = textproto.NewReader(bufio.NewReader(conn))
+			return "connected to " + conn.RemoteAddr().String(), nil
+		})
+	}
+	if ok && *diagnoseStartTLS {
+		ok = d.step("STARTTLS", func() (string, error) {
+			response, err := d.command("STARTTLS")
+			if err != nil {
+				return "", err
+			}
+			host, _, _ := net.SplitHostPort(address)
+			tlsConn := tls.Client(d.conn, &tls.Config{ServerName: host, InsecureSkipVerify: *diagnoseInsecure})
+			if err = tlsConn.Handshake(); err != nil {
+				return "", err
+			}
+			d.conn = tlsConn
+			d.reader = textproto.NewReader(bufio.NewReader(tlsConn))
+			state := tlsConn.ConnectionState()
+			return fmt.Sprintf("%s, TLS version %x, cipher %x", response, state.Version, state.CipherSuite), nil
+		})
+	}
+	if ok && c.Anonymous {
+		d.skip("USERNAME", "anonymous mode")
+		d.skip("PASSWORD", "anonymous mode")
+		d.skip("LOGIN", "anonymous mode")
+	} else if ok {
+		ok = d.step("USERNAME", func() (string, error) { return d.command("USERNAME " + c.User) }) &&
+			d.step("PASSWORD", func() (string, error) { return d.command("PASSWORD " + c.Password) }) &&
+			d.step("LOGIN", func() (string, error) { return d.command("LOGIN " + c.UpsName) })
+	}
+	if ok {
+		d.step("VER", func() (string, error) { return d.command("VER") })
+		d.step("NETVER", func() (string, error) { return d.command("NETVER") })
+		d.step("LIST VAR", func() (string, error) { return d.list("LIST VAR " + c.UpsName) })
+	}
+	if d.conn != nil {
+		_, _ = d.command("LOGOUT")
+		_ = d.conn.Close()
+	}
+	fmt.Println()
+	if d.failed {
+		fmt.Println("Diagnose failed")
+		return 1
+	}
+	fmt.Println("All steps passed")
+	return 0
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureStdout return everything printed to standard output by run
+func captureStdout(t *testing.T, run func()) string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	output := make(chan string)
+	go func() {
+		content, _ := ioutil.ReadAll(reader)
+		output <- string(content)
+	}()
+	defer func() { os.Stdout = stdout }()
+	run()
+	_ = writer.Close()
+	return <-output
+}
+
+// simulationConfig return configuration for UPS on server with address
+func simulationConfig(t *testing.T, addr, user, password string) *configData {
+	t.Helper()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newConfig()
+	c.Server, c.User, c.Password, c.UpsName = host, user, password, "ups"
+	if _, err = fmt.Sscan(port, &c.Port); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDiagnose(t *testing.T) {
+	s := startSimulation(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := listener.Addr().String()
+	_ = listener.Close()
+	timeout := *diagnoseTimeout
+	*diagnoseTimeout = 5 * time.Second
+	defer func() { *diagnoseTimeout = timeout }()
+
+	tests := []struct {
+		name     string
+		config   *configData
+		code     int
+		expected []string
+		missing  []string
+	}{
+		{
+			name:   "healthy",
+			config: simulationConfig(t, s.Addr(), "monitor", "secret"),
+			expected: []string{
+				"[ OK ] DNS", "[ OK ] Connect", "[ OK ] USERNAME", "[ OK ] PASSWORD", "[ OK ] LOGIN",
+				"[ OK ] VER", "[ OK ] NETVER", "[ OK ] LIST VAR", "4 lines, END LIST VAR ups", "All steps passed",
+			},
+			missing: []string{"[FAIL]"},
+		},
+		{
+			name:     "wrong credentials",
+			config:   simulationConfig(t, s.Addr(), "monitor", "wrong"),
+			code:     1,
+			expected: []string{"[ OK ] PASSWORD", "[FAIL] LOGIN", "ERR ACCESS-DENIED", "hint: " + diagnoseHints["ACCESS-DENIED"], "Diagnose failed"},
+			missing:  []string{"VER", "LIST VAR"},
+		},
+		{
+			name:     "unreachable port",
+			config:   simulationConfig(t, closedAddr, "monitor", "secret"),
+			code:     1,
+			expected: []string{"[ OK ] DNS", "[FAIL] Connect", "hint: upsd isn't running or doesn't listen on this address", "Diagnose failed"},
+			missing:  []string{"USERNAME"},
+		},
+	}
+	for _, test := range tests {
+		code := 0
+		output := captureStdout(t, func() { code = diagnose(test.config) })
+		if code != test.code {
+			t.Errorf("%s: expected exit code %d get %d", test.name, test.code, code)
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(output, expected) {
+				t.Errorf("%s: output doesn't contain %q:\n%s", test.name, expected, output)
+			}
+		}
+		for _, missing := range test.missing {
+			if strings.Contains(output, missing) {
+				t.Errorf("%s: output contains %q:\n%s", test.name, missing, output)
+			}
+		}
+	}
+}
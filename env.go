@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+const envPrefix = "NUT_EXPORTER_"
+
+// envName convert YAML key to environment variable name, e.g. upsName -> UPS_NAME
+func envName(key string) string {
+	var name []rune
+	for i, r := range key {
+		if unicode.IsUpper(r) && i > 0 {
+			name = append(name, '_')
+		}
+		name = append(name, unicode.ToUpper(r))
+	}
+	return string(name)
+}
+
+// applyEnv set fields from NUT_EXPORTER_* variables, nested structures use joined names
+// (NUT_EXPORTER_MQTT_BROKER) and lists or maps are YAML values, sources get variable name for key path (mqtt.broker)
+func applyEnv(value reflect.Value, prefix, path string, sources map[string]string) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if len(key) == 0 || key == "-" {
+			continue
+		}
+		name := prefix + envName(key)
+		if len(path) > 0 {
+			key = path + "." + key
+		}
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnv(value.Field(i), name+"_", key, sources); err != nil {
+				return err
+			}
+			continue
+		}
+		content, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		sources[key] = "environment variable " + name
+		if field.Type.Kind() == reflect.String {
+			value.Field(i).SetString(content)
+			continue
+		}
+		if err := unmarshalStrict([]byte(content), value.Field(i).Addr().Interface()); err != nil {
+			return errors.New("environment variable " + name + " isn't valid: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (c *configData) loadEnv() error {
+	return applyEnv(reflect.ValueOf(c).Elem(), envPrefix, "", c.sources)
+}
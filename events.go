@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/go-kit/kit/log/level"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	eventStatus = "status"
+	eventAlarm  = "alarm"
+	eventTest   = "test"
+)
+
+type upsEvent struct {
+	Time time.Time `json:"time"`
+	Ups  string    `json:"ups"`
+	Type string    `json:"type"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+}
+
+type eventHandler interface {
+	handleEvents(events []upsEvent)
+}
+
+type dataHandler interface {
+	handleData(output string, now time.Time)
+}
+
+type closeHandler interface {
+	close()
+}
+
+type eventLog struct {
+	fileName string
+	maxSize  int64         // bytes, 0 mean unlimited
+	maxAge   time.Duration // 0 mean unlimited
+	size     int64
+	oldest   time.Time
+	last     map[string]map[string]upsEvent // UPS -> event type -> last event
+	mutex    sync.Mutex
+}
+
+// newEventLog read last events of UPS from existing log and apply retention
+func newEventLog(fileName string, maxSize int64, maxAge time.Duration) *eventLog {
+	l := &eventLog{fileName: fileName, maxSize: maxSize, maxAge: maxAge, last: map[string]map[string]upsEvent{}}
+	if err := l.load(time.Now()); err != nil {
+		_ = level.Error(logger).Log("msg", "problem read event log", "file", fileName, "error", err)
+	}
+	return l
+}
+
+func (l *eventLog) handleEvents(events []upsEvent) {
+	if len(events) == 0 {
+		return
+	}
+	if err := l.append(events); err != nil {
+		_ = level.Error(logger).Log("msg", "problem write events to log", "file", l.fileName, "error", err)
+	}
+}
+
+// scan call handle for every valid event from reader
+func (l *eventLog) scan(reader io.Reader, handle func(event upsEvent)) error {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var event upsEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			_ = level.Warn(logger).Log("msg", "skip invalid line in event log", "file", l.fileName, "error", err)
+			continue
+		}
+		handle(event)
+	}
+	return scanner.Err()
+}
+
+func (l *eventLog) remember(event upsEvent) {
+	if l.oldest.IsZero() || event.Time.Before(l.oldest) {
+		l.oldest = event.Time
+	}
+	if l.last[event.Ups] == nil {
+		l.last[event.Ups] = map[string]upsEvent{}
+	}
+	l.last[event.Ups][event.Type] = event
+}
+
+// expired return true when log is bigger than max size or has events older than max age
+func (l *eventLog) expired(now time.Time) bool {
+	return (l.maxSize > 0 && l.size > l.maxSize) || (l.maxAge > 0 && !l.oldest.IsZero() && now.Sub(l.oldest) > l.maxAge)
+}
+
+func (l *eventLog) load(now time.Time) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	file, err := os.Open(l.fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	l.size = info.Size()
+	if err = l.scan(file, l.remember); err != nil {
+		return err
+	}
+	if l.expired(now) {
+		return l.compact(now)
+	}
+	return nil
+}
+
+func (l *eventLog) append(events []upsEvent) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	file, err := os.OpenFile(l.fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, event := range events {
+		if err = encoder.Encode(event); err != nil {
+			_ = file.Close()
+			return err
+		}
+		l.remember(event)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	l.size = info.Size()
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if now := time.Now(); l.expired(now) {
+		return l.compact(now)
+	}
+	return nil
+}
+
+// compact rewrite log without events older than max age, when log is bigger than max size the oldest events
+// are removed until log has half of max size, so log isn't rewritten after every append;
+// last event of every UPS and type is always kept for restore of state after start
+func (l *eventLog) compact(now time.Time) error {
+	file, err := os.Open(l.fileName)
+	if err != nil {
+		return err
+	}
+	var events []upsEvent
+	err = l.scan(file, func(event upsEvent) {
+		events = append(events, event)
+	})
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+	lastIndex := map[[2]string]int{}
+	for i, event := range events {
+		lastIndex[[2]string{event.Ups, event.Type}] = i
+	}
+	lines := make([][]byte, len(events))
+	var size int64
+	for i, event := range events {
+		isLast := lastIndex[[2]string{event.Ups, event.Type}] == i
+		if l.maxAge > 0 && now.Sub(event.Time) > l.maxAge && !isLast {
+			continue
+		}
+		if lines[i], err = json.Marshal(event); err != nil {
+			return err
+		}
+		lines[i] = append(lines[i], '\n')
+		size += int64(len(lines[i]))
+	}
+	for i, event := range events {
+		if l.maxSize == 0 || size <= l.maxSize/2 {
+			break
+		}
+		if lines[i] != nil && lastIndex[[2]string{event.Ups, event.Type}] != i {
+			size -= int64(len(lines[i]))
+			lines[i] = nil
+		}
+	}
+	l.oldest = time.Time{}
+	for i, event := range events {
+		if lines[i] != nil && (l.oldest.IsZero() || event.Time.Before(l.oldest)) {
+			l.oldest = event.Time
+		}
+	}
+	// opened files of running queries still read previous content
+	temporary := l.fileName + ".tmp"
+	if err = ioutil.WriteFile(temporary, bytes.Join(lines, nil), 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(temporary, l.fileName); err != nil {
+		_ = os.Remove(temporary)
+		return err
+	}
+	l.size = size
+	_ = level.Info(logger).Log("msg", "old events removed from log", "file", l.fileName, "size", size)
+	return nil
+}
+
+// lastEvents return last stored event of every type for UPS
+func (l *eventLog) lastEvents(ups string) map[string]upsEvent {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	events := map[string]upsEvent{}
+	for eventType, event := range l.last[ups] {
+		events[eventType] = event
+	}
+	return events
+}
+
+// query return events for UPS (all UPS when empty) in time range, zero time mean unlimited;
+// events are only appended after known size and compaction replace file, so file is read without lock
+func (l *eventLog) query(ups string, from, to time.Time) ([]upsEvent, error) {
+	events := []upsEvent{}
+	l.mutex.Lock()
+	file, err := os.Open(l.fileName)
+	size := l.size
+	l.mutex.Unlock()
+	if os.IsNotExist(err) {
+		return events, nil
+	}
+	if err != nil {
+		return events, err
+	}
+	defer file.Close()
+	if limit := time.Now().Add(-l.maxAge); l.maxAge > 0 && from.Before(limit) {
+		from = limit
+	}
+	err = l.scan(io.LimitReader(file, size), func(event upsEvent) {
+		if len(ups) > 0 && event.Ups != ups {
+			return
+		}
+		if !from.IsZero() && event.Time.Before(from) {
+			return
+		}
+		if !to.IsZero() && event.Time.After(to) {
+			return
+		}
+		events = append(events, event)
+	})
+	return events, err
+}
+
+func parseQueryTime(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, errors.New("time [" + value + "] isn't unix timestamp or RFC3339")
+	}
+	return t, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// ServeHTTP handle /api/v1/events?ups=<name>&from=<time>&to=<time>
+func (l *eventLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	from, err := parseQueryTime(r.URL.Query().Get("from"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	to, err := parseQueryTime(r.URL.Query().Get("to"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	events, err := l.query(r.URL.Query().Get("ups"), from, to)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "problem read event log", "file", l.fileName, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestEvents create event log file with events
+func writeTestEvents(t *testing.T, events []upsEvent) string {
+	t.Helper()
+	var lines []string
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(line))
+	}
+	return writeTestFile(t, "events.log", strings.Join(lines, "\n")+"\n")
+}
+
+func TestEventLogQuery(t *testing.T) {
+	l := newEventLog(filepath.Join(filepath.Dir(writeTestFile(t, "empty", "")), "events.log"), 0, 0)
+	if events, err := l.query("", time.Time{}, time.Time{}); err != nil || len(events) != 0 {
+		t.Fatalf("expected no events from missing log get %v %v", events, err)
+	}
+	now := time.Unix(1590000000, 0).UTC()
+	l.handleEvents([]upsEvent{
+		{Time: now, Ups: "ups", Type: eventStatus, From: "OL", To: "OB"},
+		{Time: now.Add(time.Minute), Ups: "other", Type: eventStatus, From: "OL", To: "OB"},
+		{Time: now.Add(2 * time.Minute), Ups: "ups", Type: eventStatus, From: "OB", To: "OL"},
+	})
+	tests := []struct {
+		name     string
+		ups      string
+		from, to time.Time
+		count    int
+	}{
+		{name: "all", count: 3},
+		{name: "ups", ups: "ups", count: 2},
+		{name: "from", from: now.Add(time.Minute), count: 2},
+		{name: "to", ups: "ups", to: now.Add(time.Minute), count: 1},
+	}
+	for _, test := range tests {
+		events, err := l.query(test.ups, test.from, test.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != test.count {
+			t.Errorf("%s: expected %d events get %v", test.name, test.count, events)
+		}
+	}
+}
+
+func TestEventLogRetention(t *testing.T) {
+	now := time.Now().UTC()
+	var events []upsEvent
+	for i := 20; i > 0; i-- {
+		events = append(events, upsEvent{Time: now.Add(-time.Duration(i) * time.Hour), Ups: "ups", Type: eventStatus, From: "OL", To: "OB"})
+	}
+	events = append(events, upsEvent{Time: now.Add(-30 * time.Hour), Ups: "ups", Type: eventAlarm, From: "", To: "Replace battery!"})
+
+	fileName := writeTestEvents(t, events)
+	l := newEventLog(fileName, 0, 10*time.Hour+time.Minute)
+	stored, err := l.query("", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 10 || !stored[0].Time.Equal(events[10].Time) {
+		t.Errorf("expected 10 events from last 10 hours get %v", stored)
+	}
+	if last := newEventLog(fileName, 0, 0).lastEvents("ups"); last[eventAlarm].To != "Replace battery!" {
+		t.Errorf("last alarm must be kept after retention get %v", last)
+	}
+
+	fileName = writeTestEvents(t, events)
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l = newEventLog(fileName, info.Size()-1, 0)
+	if l.size > l.maxSize/2 {
+		t.Errorf("log must be compacted to half of max size %d get %d", l.maxSize/2, l.size)
+	}
+	l.handleEvents([]upsEvent{{Time: now, Ups: "ups", Type: eventStatus, From: "OB", To: "OL"}})
+	if stored, err = l.query("ups", time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) >= len(events) || stored[len(stored)-1].To != "OL" {
+		t.Errorf("expected oldest events removed get %v", stored)
+	}
+	if info, err = os.Stat(fileName); err != nil || info.Size() != l.size {
+		t.Errorf("tracked size %d doesn't match file %v %v", l.size, info, err)
+	}
+}
+
+func TestStatusTrackerRestore(t *testing.T) {
+	stopped := time.Unix(1590000000, 0).UTC()
+	l := newEventLog(writeTestEvents(t, []upsEvent{
+		{Time: stopped.Add(-time.Hour), Ups: "ups", Type: eventStatus, From: "OB", To: "OL"},
+		{Time: stopped, Ups: "ups", Type: eventStatus, From: "OL", To: "OB"},
+		{Time: stopped, Ups: "other", Type: eventStatus, From: "OB", To: "OL"},
+	}), 0, 0)
+
+	tracker := newStatusTracker("ups")
+	tracker.restore(l.lastEvents("ups"))
+	now := stopped.Add(time.Hour)
+	events := tracker.update("ups.status: OL CHRG\nups.alarm: Replace battery!", now)
+	if len(events) != 1 || events[0].Type != eventStatus || events[0].From != "OB" || events[0].To != "OL CHRG" {
+		t.Errorf("expected status change made while exporter didn't run get %v", events)
+	}
+	if events = tracker.update("ups.status: OL CHRG\nups.alarm: Replace battery!", now.Add(time.Minute)); len(events) != 0 {
+		t.Errorf("alarm without stored event must be baseline get %v", events)
+	}
+
+	tracker = newStatusTracker("new")
+	tracker.restore(l.lastEvents("new"))
+	if events = tracker.update("ups.status: OB", now); len(events) != 0 {
+		t.Errorf("first poll of UPS without stored events must be baseline get %v", events)
+	}
+}
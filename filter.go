@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// filter pattern is glob (driver.parameter.*) or regex between slashes (/^ups\.(id|serial)$/)
+
+type nameFilter struct {
+	Include []string `yaml:"include" json:"include"`
+	Exclude []string `yaml:"exclude" json:"exclude"`
+}
+
+type upsFilterConfig struct {
+	Variables nameFilter `yaml:"variables" json:"variables"`
+	Metrics   nameFilter `yaml:"metrics" json:"metrics"`
+}
+
+type filterConfig struct {
+	Variables nameFilter                 `yaml:"variables" json:"variables"`
+	Metrics   nameFilter                 `yaml:"metrics" json:"metrics"`
+	Ups       map[string]upsFilterConfig `yaml:"ups" json:"ups"`
+}
+
+type nameMatcher struct {
+	include []func(string) bool
+	exclude []func(string) bool
+}
+
+// metricFilter select NUT variables and metric names used for metrics, nil filter allow all
+type metricFilter struct {
+	variables nameMatcher
+	metrics   nameMatcher
+}
+
+func compileNamePattern(pattern string) (func(string) bool, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		regex, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("filter regex %s isn't valid: %s", pattern, err)
+		}
+		return regex.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("filter pattern %s isn't valid: %s", pattern, err)
+	}
+	return func(name string) bool {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}, nil
+}
+
+func compileNamePatterns(patterns []string) ([]func(string) bool, error) {
+	var matchers []func(string) bool
+	for _, pattern := range patterns {
+		matcher, err := compileNamePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+// add append patterns from filter to matcher
+func (m *nameMatcher) add(f nameFilter) error {
+	include, err := compileNamePatterns(f.Include)
+	if err != nil {
+		return err
+	}
+	exclude, err := compileNamePatterns(f.Exclude)
+	if err != nil {
+		return err
+	}
+	m.include = append(m.include, include...)
+	m.exclude = append(m.exclude, exclude...)
+	return nil
+}
+
+func matchAny(matchers []func(string) bool, name string) bool {
+	for _, matcher := range matchers {
+		if matcher(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed return true when name match any include pattern (or include list is empty) and doesn't match any exclude pattern
+func (m *nameMatcher) allowed(name string) bool {
+	if len(m.include) > 0 && !matchAny(m.include, name) {
+		return false
+	}
+	return !matchAny(m.exclude, name)
+}
+
+func (f *metricFilter) variableAllowed(name string) bool {
+	return f == nil || f.variables.allowed(name)
+}
+
+func (f *metricFilter) metricAllowed(name string) bool {
+	return f == nil || f.metrics.allowed(name)
+}
+
+// filter return filter for UPS, global patterns are joined with patterns for UPS
+func (c *filterConfig) filter(upsName string) (*metricFilter, error) {
+	f := &metricFilter{}
+	if err := f.variables.add(c.Variables); err != nil {
+		return nil, err
+	}
+	if err := f.metrics.add(c.Metrics); err != nil {
+		return nil, err
+	}
+	if ups, ok := c.Ups[upsName]; ok {
+		if err := f.variables.add(ups.Variables); err != nil {
+			return nil, err
+		}
+		if err := f.metrics.add(ups.Metrics); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// validate return error for every invalid pattern
+func (c *filterConfig) validate() []error {
+	var errs []error
+	check := func(prefix string, f nameFilter) {
+		for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+			if _, err := compileNamePattern(pattern); err != nil {
+				errs = append(errs, errors.New(prefix+err.Error()))
+			}
+		}
+	}
+	check("", c.Variables)
+	check("", c.Metrics)
+	names := make([]string, 0, len(c.Ups))
+	for name := range c.Ups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		check(fmt.Sprintf("UPS [%s]: ", name), c.Ups[name].Variables)
+		check(fmt.Sprintf("UPS [%s]: ", name), c.Ups[name].Metrics)
+	}
+	return errs
+}
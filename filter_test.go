@@ -0,0 +1,132 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileNamePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+		err     string
+	}{
+		{pattern: "driver.parameter.*", name: "driver.parameter.pollinterval", match: true},
+		{pattern: "driver.parameter.*", name: "driver.name"},
+		{pattern: "battery.?harge", name: "battery.charge", match: true},
+		{pattern: "ups.status", name: "ups.status", match: true},
+		{pattern: "nut_battery_*", name: "nut_battery_charge", match: true},
+		{pattern: `/^ups\.(id|serial)$/`, name: "ups.serial", match: true},
+		{pattern: `/^ups\.(id|serial)$/`, name: "ups.serial.number"},
+		{pattern: "/charge/", name: "battery.charge.low", match: true},
+		{pattern: "/", name: "/", match: true},
+		{pattern: "/ups.(id/", err: "filter regex /ups.(id/ isn't valid"},
+		{pattern: "ups.[status", err: "filter pattern ups.[status isn't valid"},
+	}
+	for _, test := range tests {
+		matcher, err := compileNamePattern(test.pattern)
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error %q get %v", test.pattern, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.pattern, err)
+			continue
+		}
+		if match := matcher(test.name); match != test.match {
+			t.Errorf("%s: expected match of %s %v", test.pattern, test.name, test.match)
+		}
+	}
+}
+
+func TestFilterAllowed(t *testing.T) {
+	c := filterConfig{
+		Variables: nameFilter{Include: []string{"battery.*", "ups.*"}, Exclude: []string{"ups.serial"}},
+		Metrics:   nameFilter{Exclude: []string{"/_nominal$/"}},
+		Ups: map[string]upsFilterConfig{
+			"rack": {Variables: nameFilter{Include: []string{"input.voltage"}, Exclude: []string{"battery.charge.*"}}},
+		},
+	}
+	tests := []struct {
+		ups     string
+		name    string
+		allowed bool
+	}{
+		{ups: "ups", name: "battery.charge", allowed: true},
+		{ups: "ups", name: "ups.serial"},
+		{ups: "ups", name: "input.voltage"},
+		{ups: "ups", name: "battery.charge.low", allowed: true},
+		// global and UPS patterns are joined, exclude wins over include
+		{ups: "rack", name: "input.voltage", allowed: true},
+		{ups: "rack", name: "battery.charge", allowed: true},
+		{ups: "rack", name: "battery.charge.low"},
+		{ups: "rack", name: "ups.serial"},
+	}
+	for _, test := range tests {
+		f, err := c.filter(test.ups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := f.variableAllowed(test.name); allowed != test.allowed {
+			t.Errorf("%s %s: expected allowed %v", test.ups, test.name, test.allowed)
+		}
+	}
+	f, _ := c.filter("ups")
+	if f.metricAllowed("nut_ups_power_nominal") || !f.metricAllowed("nut_ups_load") {
+		t.Error("metric filter doesn't exclude by regex")
+	}
+	var none *metricFilter
+	if !none.variableAllowed("ups.serial") || !none.metricAllowed("nut_ups_load") {
+		t.Error("nil filter must allow all")
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	c := filterConfig{
+		Variables: nameFilter{Include: []string{"/(/"}, Exclude: []string{"ups.*"}},
+		Metrics:   nameFilter{Exclude: []string{"nut_[battery"}},
+		Ups:       map[string]upsFilterConfig{"rack": {Metrics: nameFilter{Include: []string{"/[/"}}}},
+	}
+	errs := c.validate()
+	if len(errs) != 3 || !strings.HasPrefix(errs[2].Error(), "UPS [rack]: filter regex /[/") {
+		t.Errorf("expected 3 errors get %v", errs)
+	}
+	if errs = (&filterConfig{Variables: nameFilter{Include: []string{"ups.*", "/^battery/"}}}).validate(); len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestMappingCollectorFilter(t *testing.T) {
+	mappings, err := loadMetricMappings("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := filterConfig{
+		Variables: nameFilter{Exclude: []string{"battery.voltage"}},
+		Metrics:   nameFilter{Include: []string{"nut_battery_*", "nut_ups_load"}},
+	}
+	filter, err := c.filter("ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector := &mappingCollector{}
+	collector.update(mappings, filter, "battery.charge: 100\nbattery.voltage: 13.5\nups.load: 20\ninput.voltage: 230")
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	if expected := []string{"nut_battery_charge", "nut_ups_load"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected metrics %v get %v", expected, names)
+	}
+}
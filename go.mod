@@ -3,9 +3,14 @@ module github.com/pokornyIt/nut_exporter
 go 1.14
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.3.5
 	github.com/go-kit/kit v0.9.0
+	github.com/golang/snappy v0.0.4
 	github.com/prometheus/client_golang v1.7.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/prometheus/common v0.10.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	google.golang.org/protobuf v1.23.0
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
-	gopkg.in/yaml.v2 v2.2.5
+	gopkg.in/yaml.v3 v3.0.1
 )
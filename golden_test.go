@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// golden files: <device>.txt is output of "upsc <ups>", <device>.prom is expected metrics
+
+var goldenUpdate = flag.Bool("update", false, "write actual metrics into expected metrics files in testdata/golden")
+
+// goldenMetrics run upsc output through metrics update and return metrics in Prometheus text format
+func goldenMetrics(mappings []metricMapping, output string) (string, error) {
+	collector := &mappingCollector{}
+	collector.update(mappings, nil, output)
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		return "", err
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		return "", err
+	}
+	var buffer bytes.Buffer
+	encoder := expfmt.NewEncoder(&buffer, expfmt.FmtText)
+	for _, family := range families {
+		if err = encoder.Encode(family); err != nil {
+			return "", err
+		}
+	}
+	return buffer.String(), nil
+}
+
+// goldenDiff return lines missing in actual metrics (-) and unexpected lines (+)
+func goldenDiff(expected, actual string) []string {
+	var diff []string
+	expectedLines := map[string]bool{}
+	for _, line := range strings.Split(expected, "\n") {
+		expectedLines[line] = true
+	}
+	actualLines := map[string]bool{}
+	for _, line := range strings.Split(actual, "\n") {
+		actualLines[line] = true
+		if !expectedLines[line] {
+			diff = append(diff, "+ "+line)
+		}
+	}
+	for _, line := range strings.Split(expected, "\n") {
+		if !actualLines[line] {
+			diff = append(diff, "- "+line)
+		}
+	}
+	return diff
+}
+
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "golden", "*.txt"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no upsc outputs (*.txt) in testdata/golden: %v", err)
+	}
+	mappings, err := loadMetricMappings("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".txt"), func(t *testing.T) {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			output := strings.TrimRight(strings.Replace(string(content), "\r\n", "\n", -1), "\n")
+			actual, err := goldenMetrics(mappings, output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedFile := strings.TrimSuffix(file, ".txt") + ".prom"
+			if *goldenUpdate {
+				if err = ioutil.WriteFile(expectedFile, []byte(actual), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := ioutil.ReadFile(expectedFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(expected) != actual {
+				t.Errorf("metrics differ from %s:\n%s", expectedFile, strings.Join(goldenDiff(string(expected), actual), "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+type upsHealth struct {
+	Name                string    `json:"name"`
+	LastSuccess         time.Time `json:"lastSuccess"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Error               string    `json:"error"`
+	Ready               bool      `json:"ready"`
+}
+
+type readiness struct {
+	Ready bool        `json:"ready"`
+	Ups   []upsHealth `json:"ups"`
+}
+
+// healthyHandler handle /-/healthy, report only that process is alive
+func healthyHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
+}
+
+// readiness is ready after first successful poll, UPS isn't ready after threshold failed polls in row
+// and exporter isn't ready when no UPS is ready
+func (s *stateStore) readiness(threshold int) readiness {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := readiness{Ups: []upsHealth{}}
+	for _, snapshot := range s.ups {
+		health := upsHealth{
+			Name:                snapshot.Name,
+			LastSuccess:         snapshot.LastSuccess,
+			ConsecutiveFailures: snapshot.Failures,
+			Error:               snapshot.Error,
+			Ready:               !snapshot.LastSuccess.IsZero() && snapshot.Failures < threshold,
+		}
+		result.Ready = result.Ready || health.Ready
+		result.Ups = append(result.Ups, health)
+	}
+	sort.Slice(result.Ups, func(i, j int) bool { return result.Ups[i].Name < result.Ups[j].Name })
+	return result
+}
+
+// readyHandler handle /-/ready
+func readyHandler(w http.ResponseWriter, _ *http.Request) {
+	result := state.readiness(currentConfig().ReadyFailures)
+	status := http.StatusOK
+	if !result.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, result)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useTestState replace global state of UPS for test
+func useTestState(t *testing.T) *stateStore {
+	t.Helper()
+	previous := state
+	state = newStateStore()
+	t.Cleanup(func() { state = previous })
+	return state
+}
+
+func TestReadyHandler(t *testing.T) {
+	s := useTestState(t)
+	threshold := currentConfig().ReadyFailures
+	now := time.Unix(1590000000, 0).UTC()
+	ready := func(step string, expected bool) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		readyHandler(recorder, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+		var result readiness
+		if err := json.NewDecoder(recorder.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		status := http.StatusServiceUnavailable
+		if expected {
+			status = http.StatusOK
+		}
+		if recorder.Code != status || result.Ready != expected {
+			t.Errorf("%s: expected status %d ready %v get %d %+v", step, status, expected, recorder.Code, result)
+		}
+	}
+
+	ready("no UPS", false)
+	s.register("ups", "nut:3493")
+	ready("before first poll", false)
+	s.update("ups", "nut:3493", "", errors.New("connection refused"), now)
+	ready("before first success", false)
+	s.update("ups", "nut:3493", "ups.status: OL", nil, now)
+	ready("after success", true)
+	for i := 1; i < threshold; i++ {
+		s.update("ups", "nut:3493", "", errors.New("connection refused"), now)
+		ready("failures below threshold", true)
+	}
+	s.update("ups", "nut:3493", "", errors.New("connection refused"), now)
+	ready("failures reach threshold", false)
+	s.update("ups", "nut:3493", "ups.status: OL", nil, now)
+	ready("success after failures", true)
+}
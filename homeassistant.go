@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/go-kit/kit/log/level"
+	"regexp"
+	"strings"
+)
+
+// Home Assistant MQTT discovery https://www.home-assistant.io/integrations/sensor.mqtt/
+
+// haSensor is hint for known variable, other variables are published with name created from variable
+type haSensor struct {
+	name        string
+	deviceClass string
+	unit        string
+}
+
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	SwVersion    string   `json:"sw_version,omitempty"`
+}
+
+type haConfig struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic"`
+	AvailabilityTopic string   `json:"availability_topic"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	Unit              string   `json:"unit_of_measurement,omitempty"`
+	StateClass        string   `json:"state_class,omitempty"`
+	Device            haDevice `json:"device"`
+}
+
+var haSensorHints = map[string]haSensor{
+	"battery.charge":          {"Battery charge", "battery", "%"},
+	"battery.charge.low":      {"Battery charge low", "battery", "%"},
+	"battery.charge.warning":  {"Battery charge warning", "battery", "%"},
+	"battery.runtime":         {"Battery runtime", "duration", "s"},
+	"battery.temperature":     {"Battery temperature", "temperature", "°C"},
+	"battery.voltage":         {"Battery voltage", "voltage", "V"},
+	"battery.voltage.nominal": {"Battery voltage nominal", "voltage", "V"},
+	"input.frequency":         {"Input frequency", "frequency", "Hz"},
+	"input.voltage":           {"Input voltage", "voltage", "V"},
+	"input.voltage.nominal":   {"Input voltage nominal", "voltage", "V"},
+	"output.frequency":        {"Output frequency", "frequency", "Hz"},
+	"output.voltage":          {"Output voltage", "voltage", "V"},
+	"output.voltage.nominal":  {"Output voltage nominal", "voltage", "V"},
+	"ups.delay.shutdown":      {"Delay shutdown", "duration", "s"},
+	"ups.delay.start":         {"Delay start", "duration", "s"},
+	"ups.load":                {"Load", "", "%"},
+	"ups.power":               {"Apparent power", "apparent_power", "VA"},
+	"ups.power.nominal":       {"Apparent power nominal", "apparent_power", "VA"},
+	"ups.realpower":           {"Real power", "power", "W"},
+	"ups.realpower.nominal":   {"Real power nominal", "power", "W"},
+	"ups.temperature":         {"Temperature", "temperature", "°C"},
+}
+
+var haIDRegex = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+func haObjectID(parts ...string) string {
+	return strings.Trim(haIDRegex.ReplaceAllString(strings.Join(parts, "_"), "_"), "_")
+}
+
+func (p *mqttPublisher) haDevice(vars map[string]string) haDevice {
+	id := vars["ups.serial"]
+	if len(id) == 0 {
+		id = vars["device.serial"]
+	}
+	if len(id) == 0 {
+		id = haObjectID(p.prefix)
+	}
+	return haDevice{
+		Identifiers:  []string{id},
+		Name:         p.ups,
+		Manufacturer: vars["ups.mfr"],
+		Model:        vars["ups.model"],
+		SwVersion:    vars["ups.firmware"],
+	}
+}
+
+// haSensorName create sensor name from variable like "Input current nominal" for input.current.nominal
+func haSensorName(variable string) string {
+	name := strings.ReplaceAll(strings.TrimPrefix(variable, "ups."), ".", " ")
+	if len(name) == 0 {
+		return variable
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// publishDiscovery publish retained sensor config for each polled variable without config published yet,
+// known variables have device class and unit from hints
+func (p *mqttPublisher) publishDiscovery(vars map[string]string) {
+	prefix := p.config.DiscoveryPrefix
+	if len(prefix) == 0 {
+		prefix = "homeassistant"
+	}
+	device := p.haDevice(vars)
+	count := 0
+	for variable := range vars {
+		if p.discovered[variable] {
+			continue
+		}
+		sensor, ok := haSensorHints[variable]
+		if !ok {
+			sensor = haSensor{name: haSensorName(variable)}
+		}
+		objectID := haObjectID(p.ups, variable)
+		c := haConfig{
+			Name:              sensor.name,
+			UniqueID:          haObjectID(applicationName, device.Identifiers[0], variable),
+			StateTopic:        p.topic(variable),
+			AvailabilityTopic: p.topic("availability"),
+			DeviceClass:       sensor.deviceClass,
+			Unit:              sensor.unit,
+			Device:            device,
+		}
+		if len(sensor.unit) > 0 {
+			c.StateClass = "measurement"
+		}
+		content, err := json.Marshal(c)
+		if err != nil {
+			continue
+		}
+		p.publish(prefix+"/sensor/"+objectID+"/config", true, content)
+		p.discovered[variable] = true
+		count++
+	}
+	_ = level.Debug(logger).Log("msg", "published Home Assistant discovery configs", "sensors", count)
+}
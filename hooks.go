@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log/level"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+)
+
+type hookConfig struct {
+	Flag    string   `yaml:"flag" json:"flag"`
+	Command []string `yaml:"command" json:"command"`
+	Delay   int      `yaml:"delay" json:"delay"`
+	Timeout int      `yaml:"timeout" json:"timeout"`
+}
+
+type hookRunner struct {
+	hooks     []hookConfig
+	ups       string
+	timers    map[int]*time.Timer
+	deadlines map[int]time.Time
+	vars      map[string]string
+	evaluated bool // flags of first observed status are evaluated
+	mutex     sync.Mutex
+}
+
+var hookFlagRegex = regexp.MustCompile(`^[A-Z]+$`)
+
+func (h *hookConfig) validate() []error {
+	var errs []error
+	if !hookFlagRegex.MatchString(h.Flag) {
+		errs = append(errs, errors.New("hook flag ["+h.Flag+"] isn't valid UPS status flag (OB, LB, FSD, ...)"))
+	}
+	if len(h.Command) < 1 {
+		errs = append(errs, errors.New("hook command for flag ["+h.Flag+"] must be defined"))
+	}
+	if h.Delay < 0 || h.Delay > 3600 {
+		errs = append(errs, errors.New("hook delay is out of range (0-3600 sec)"))
+	}
+	if h.Timeout < 0 || h.Timeout > 3600 {
+		errs = append(errs, errors.New("hook timeout is out of range (0-3600 sec)"))
+	}
+	return errs
+}
+
+func newHookRunner(hooks []hookConfig, ups string) *hookRunner {
+	return &hookRunner{hooks: hooks, ups: ups, timers: map[int]*time.Timer{}, deadlines: map[int]time.Time{}, vars: map[string]string{}}
+}
+
+// handleData keep variables for hook environment, hooks with flag in first observed status are started,
+// because status tracker create events only for changes
+func (r *hookRunner) handleData(output string, _ time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.vars = parseVarList(output)
+	status, ok := r.vars["ups.status"]
+	if r.evaluated || !ok {
+		return
+	}
+	r.evaluated = true
+	flags := parseStatusFlags(status)
+	for i, hook := range r.hooks {
+		if flags[hook.Flag] {
+			_ = level.Warn(logger).Log("msg", "exporter started while UPS status flag is set, start hook timer", "ups", r.ups, "flag", hook.Flag)
+			r.start(i, time.Duration(hook.Delay)*time.Second)
+		}
+	}
+}
+
+// handleEvents start timer for hook when flag appear and cancel it when flag disappear before delay expires
+func (r *hookRunner) handleEvents(events []upsEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, event := range events {
+		if event.Type != eventStatus {
+			continue
+		}
+		from := parseStatusFlags(event.From)
+		to := parseStatusFlags(event.To)
+		for i, hook := range r.hooks {
+			if to[hook.Flag] && !from[hook.Flag] {
+				r.start(i, time.Duration(r.hooks[i].Delay)*time.Second)
+			}
+			if !to[hook.Flag] && from[hook.Flag] {
+				r.cancel(i)
+			}
+		}
+	}
+}
+
+func (r *hookRunner) start(index int, delay time.Duration) {
+	hook := r.hooks[index]
+	if _, ok := r.timers[index]; ok {
+		return
+	}
+	_ = level.Info(logger).Log("msg", "UPS status flag appear, start hook timer", "ups", r.ups, "flag", hook.Flag, "delay", delay)
+	r.deadlines[index] = time.Now().Add(delay)
+	r.timers[index] = time.AfterFunc(delay, func() {
+		r.mutex.Lock()
+		delete(r.timers, index)
+		delete(r.deadlines, index)
+		env := r.environment(r.ups, hook.Flag)
+		r.mutex.Unlock()
+		r.run(hook, env)
+	})
+}
+
+// takeOver move pending timers from runner of previous configuration, timer of same hook keep remaining delay
+// and timer of hook which isn't in new configuration is canceled; new hook with flag in actual status is started
+func (r *hookRunner) takeOver(previous *hookRunner) {
+	previous.mutex.Lock()
+	defer previous.mutex.Unlock()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if previous.ups != r.ups {
+		return
+	}
+	r.vars = previous.vars
+	r.evaluated = previous.evaluated
+	if r.evaluated {
+		flags := parseStatusFlags(r.vars["ups.status"])
+		for i, hook := range r.hooks {
+			if !flags[hook.Flag] || containsHook(previous.hooks, hook) {
+				continue
+			}
+			_ = level.Warn(logger).Log("msg", "hook added while UPS status flag is set, start hook timer", "ups", r.ups, "flag", hook.Flag)
+			r.start(i, time.Duration(hook.Delay)*time.Second)
+		}
+	}
+	for index, timer := range previous.timers {
+		hook := previous.hooks[index]
+		remaining := time.Until(previous.deadlines[index])
+		if !timer.Stop() {
+			// hook command already started
+			continue
+		}
+		delete(previous.timers, index)
+		delete(previous.deadlines, index)
+		found := false
+		for i := range r.hooks {
+			if reflect.DeepEqual(r.hooks[i], hook) {
+				if remaining < 0 {
+					remaining = 0
+				}
+				r.start(i, remaining)
+				found = true
+			}
+		}
+		if !found {
+			_ = level.Info(logger).Log("msg", "hook removed from configuration, cancel hook timer", "flag", hook.Flag)
+		}
+	}
+}
+
+func containsHook(hooks []hookConfig, hook hookConfig) bool {
+	for _, h := range hooks {
+		if reflect.DeepEqual(h, hook) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *hookRunner) cancel(index int) {
+	timer, ok := r.timers[index]
+	if !ok {
+		return
+	}
+	if timer.Stop() {
+		_ = level.Info(logger).Log("msg", "UPS status flag disappear, cancel hook timer", "flag", r.hooks[index].Flag)
+	}
+	delete(r.timers, index)
+	delete(r.deadlines, index)
+}
+
+func (r *hookRunner) environment(ups, flag string) []string {
+	return append(os.Environ(),
+		"NUT_UPS="+ups,
+		"NUT_EVENT="+flag,
+		"NUT_FLAGS="+r.vars["ups.status"],
+		"NUT_BATTERY_CHARGE="+r.vars["battery.charge"],
+		"NUT_BATTERY_RUNTIME="+r.vars["battery.runtime"],
+	)
+}
+
+func (r *hookRunner) run(hook hookConfig, env []string) {
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = 60
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = env
+	_ = level.Info(logger).Log("msg", "run hook command", "flag", hook.Flag, "command", hook.Command[0])
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		_ = level.Error(logger).Log("msg", "hook command timed out", "flag", hook.Flag, "command", hook.Command[0], "timeout", timeout)
+		return
+	}
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "hook command failed", "flag", hook.Flag, "command", hook.Command[0], "error", err, "output", string(output))
+		return
+	}
+	_ = level.Debug(logger).Log("msg", "hook command finished", "flag", hook.Flag, "command", hook.Command[0], "output", string(output))
+}
+
+func (r *hookRunner) close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for index := range r.timers {
+		r.cancel(index)
+	}
+}
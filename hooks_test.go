@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHookRunnerFirstStatus(t *testing.T) {
+	output := filepath.Join(filepath.Dir(writeTestFile(t, "empty", "")), "hook.out")
+	runner := newHookRunner([]hookConfig{
+		{Flag: "OB", Command: []string{"sh", "-c", `echo "$NUT_UPS $NUT_EVENT $NUT_FLAGS $NUT_BATTERY_CHARGE" > ` + output}},
+		{Flag: "FSD", Command: []string{"true"}, Delay: 3600},
+	}, "ups")
+	defer runner.close()
+	runner.handleData("ups.status: OB LB\nbattery.charge: 15", time.Now())
+	waitFor(t, func() bool {
+		content, err := ioutil.ReadFile(output)
+		return err == nil && strings.TrimSpace(string(content)) == "ups OB OB LB 15"
+	})
+	runner.mutex.Lock()
+	if len(runner.timers) != 0 {
+		t.Errorf("hook without flag in status is started: %v", runner.timers)
+	}
+	runner.mutex.Unlock()
+
+	// next poll with same status doesn't start hook again
+	runner.handleData("ups.status: OB LB FSD\nbattery.charge: 10", time.Now())
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+	if len(runner.timers) != 0 {
+		t.Errorf("hook is started without status event: %v", runner.timers)
+	}
+}
+
+func TestHookRunnerEvents(t *testing.T) {
+	runner := newHookRunner([]hookConfig{{Flag: "OB", Command: []string{"true"}, Delay: 3600}}, "ups")
+	defer runner.close()
+	runner.handleData("ups.status: OL", time.Now())
+	runner.handleEvents([]upsEvent{{Type: eventStatus, From: "OL", To: "OB DISCHRG"}})
+	if _, ok := runner.timers[0]; !ok {
+		t.Fatal("hook timer isn't started when flag appear")
+	}
+	runner.handleEvents([]upsEvent{{Type: eventAlarm, From: "", To: "OB"}})
+	runner.handleEvents([]upsEvent{{Type: eventStatus, From: "OB DISCHRG", To: "OB"}})
+	if _, ok := runner.timers[0]; !ok {
+		t.Fatal("hook timer is canceled while flag is set")
+	}
+	runner.handleEvents([]upsEvent{{Type: eventStatus, From: "OB", To: "OL CHRG"}})
+	if len(runner.timers) != 0 || len(runner.deadlines) != 0 {
+		t.Errorf("hook timer isn't canceled when flag disappear: %v", runner.timers)
+	}
+}
+
+func TestHookRunnerTakeOverFirstStatus(t *testing.T) {
+	hooks := []hookConfig{
+		{Flag: "OB", Command: []string{"true"}, Delay: 3600},
+		{Flag: "OB", Command: []string{"true", "added"}, Delay: 3600},
+	}
+	previous := newHookRunner(hooks[:1], "ups")
+	previous.handleData("ups.status: OB", time.Now())
+	previous.close()
+
+	// hook of previous configuration was canceled or finished, reload must not start it again
+	runner := newHookRunner(hooks, "ups")
+	defer runner.close()
+	runner.takeOver(previous)
+	runner.handleData("ups.status: OB", time.Now())
+	if _, ok := runner.timers[0]; ok {
+		t.Error("hook from previous configuration is started again")
+	}
+	if _, ok := runner.timers[1]; !ok {
+		t.Error("hook added while flag is set isn't started")
+	}
+}
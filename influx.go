@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// line protocol https://docs.influxdata.com/influxdb/v1.8/write_protocols/line_protocol_reference/
+
+const influxMaxPending = 10000
+
+type influxConfig struct {
+	URL         string `yaml:"url" json:"url"`
+	Token       string `yaml:"token" json:"token"`
+	User        string `yaml:"user" json:"user"`
+	Password    string `yaml:"password" json:"password"`
+	Measurement string `yaml:"measurement" json:"measurement"`
+	BatchSize   int    `yaml:"batchSize" json:"batchSize"`
+	Retries     int    `yaml:"retries" json:"retries"`
+	Pull        bool   `yaml:"pull" json:"pull"`
+}
+
+type influxWriter struct {
+	config  influxConfig
+	server  string
+	ups     string
+	client  *http.Client
+	latest  string
+	pending []string
+	sending bool
+	skipped map[string]bool
+	closed  bool
+	done    chan struct{}
+	flushes sync.WaitGroup
+	mutex   sync.Mutex
+}
+
+var (
+	influxTagEscape    = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+	influxMeasurement  = strings.NewReplacer(",", "\\,", " ", "\\ ")
+	influxStringEscape = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+	// variables which are text even when value looks like number (serial 0123, firmware 2.1)
+	influxStringVars = regexp.MustCompile(`^ups[.]status$|[.](serial|firmware|firmware[.]aux|id|version|version[.].*|date|mfr|model|type|vendorid|productid|name|status)$`)
+	// variables stored as tags, they identify device and don't change
+	influxTagVars = map[string]string{"ups.model": "model", "ups.serial": "serial", "device.serial": "serial"}
+)
+
+func (i *influxConfig) enabled() bool {
+	return len(i.URL) > 0 || i.Pull
+}
+
+func (i *influxConfig) validate() []error {
+	var errs []error
+	if len(i.URL) > 0 {
+		u, err := url.Parse(i.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, errors.New("InfluxDB URL must be valid http:// or https:// URL"))
+		}
+	}
+	if i.BatchSize < 0 || i.BatchSize > 1000 {
+		errs = append(errs, errors.New("InfluxDB batch size is out of range (0-1000)"))
+	}
+	if i.Retries < 0 || i.Retries > 10 {
+		errs = append(errs, errors.New("InfluxDB retries is out of range (0-10)"))
+	}
+	return errs
+}
+
+func newInfluxWriter(c influxConfig, server, ups string) *influxWriter {
+	if len(c.Measurement) == 0 {
+		c.Measurement = "ups"
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 1
+	}
+	return &influxWriter{
+		config:  c,
+		server:  server,
+		ups:     ups,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		skipped: map[string]bool{},
+		done:    make(chan struct{}),
+	}
+}
+
+// influxLine convert variables to one line, numeric variables are float fields, known text variables
+// are string fields, ups, server, model and serial are tags, other text variables are skipped and returned
+func influxLine(measurement, server, ups string, vars map[string]string, now time.Time) (string, []string) {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var fields, skipped []string
+	numeric := false
+	tags := map[string]string{}
+	for _, name := range names {
+		if tag, ok := influxTagVars[name]; ok && len(vars[name]) > 0 {
+			if _, exists := tags[tag]; !exists {
+				tags[tag] = vars[name]
+			}
+		}
+		if influxStringVars.MatchString(name) {
+			fields = append(fields, influxTagEscape.Replace(name)+"=\""+influxStringEscape.Replace(vars[name])+"\"")
+			continue
+		}
+		value, err := strconv.ParseFloat(vars[name], 64)
+		if err != nil {
+			skipped = append(skipped, name)
+			continue
+		}
+		if !isFinite(value) {
+			continue
+		}
+		numeric = true
+		fields = append(fields, influxTagEscape.Replace(name)+"="+strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	if !numeric {
+		return "", skipped
+	}
+	line := influxMeasurement.Replace(measurement) + ",server=" + influxTagEscape.Replace(server) + ",ups=" + influxTagEscape.Replace(ups)
+	for _, tag := range []string{"model", "serial"} {
+		if value := tags[tag]; len(value) > 0 {
+			line += "," + tag + "=" + influxTagEscape.Replace(value)
+		}
+	}
+	return fmt.Sprintf("%s %s %d", line, strings.Join(fields, ","), now.UnixNano()), skipped
+}
+
+// handleData add line to queue and start flush when batch is full, data after close are ignored
+func (w *influxWriter) handleData(output string, now time.Time) {
+	line, skipped := influxLine(w.config.Measurement, w.server, w.ups, parseVarList(output), now)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, name := range skipped {
+		if !w.skipped[name] {
+			w.skipped[name] = true
+			_ = level.Debug(logger).Log("msg", "InfluxDB skip text variable, it isn't number and isn't known text variable", "variable", name, "ups", w.ups)
+		}
+	}
+	if len(line) == 0 || w.closed {
+		return
+	}
+	w.latest = line
+	if len(w.config.URL) == 0 {
+		return
+	}
+	w.pending = append(w.pending, line)
+	if len(w.pending) > influxMaxPending {
+		w.pending = w.pending[len(w.pending)-influxMaxPending:]
+	}
+	if len(w.pending) >= w.config.BatchSize && !w.sending {
+		batch := w.pending
+		w.pending = nil
+		w.sending = true
+		w.flushes.Add(1)
+		go w.flush(batch)
+	}
+}
+
+// flush write batch with retry, not written lines are returned to queue for next poll or close
+func (w *influxWriter) flush(batch []string) {
+	defer w.flushes.Done()
+	err := w.writeRetry(batch)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.sending = false
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "problem write data to InfluxDB", "url", w.config.URL, "lines", len(batch), "error", err)
+		w.pending = append(batch, w.pending...)
+		return
+	}
+	_ = level.Debug(logger).Log("msg", "data written to InfluxDB", "lines", len(batch))
+}
+
+// writeRetry write batch, failed write is repeated with exponential backoff until writer is closed
+func (w *influxWriter) writeRetry(batch []string) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := w.write(batch)
+		if err == nil || attempt >= w.config.Retries {
+			return err
+		}
+		select {
+		case <-w.done:
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *influxWriter) write(batch []string) error {
+	request, err := http.NewRequest(http.MethodPost, w.config.URL, bytes.NewBufferString(strings.Join(batch, "\n")+"\n"))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if len(w.config.Token) > 0 {
+		request.Header.Set("Authorization", "Token "+w.config.Token)
+	} else if len(w.config.User) > 0 {
+		request.SetBasicAuth(w.config.User, w.config.Password)
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("server returned status %s", response.Status)
+	}
+	return nil
+}
+
+// close stop retry of running flush and write all queued lines once
+func (w *influxWriter) close() {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.done)
+	w.mutex.Unlock()
+	w.flushes.Wait()
+	w.mutex.Lock()
+	batch := w.pending
+	w.pending = nil
+	w.mutex.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	if err := w.write(batch); err != nil {
+		_ = level.Error(logger).Log("msg", "problem write data to InfluxDB on close, data are lost", "url", w.config.URL, "lines", len(batch), "error", err)
+		return
+	}
+	_ = level.Debug(logger).Log("msg", "queued data written to InfluxDB on close", "lines", len(batch))
+}
+
+// ServeHTTP return line for latest poll on /influx
+func (w *influxWriter) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	w.mutex.Lock()
+	line := w.latest
+	w.mutex.Unlock()
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(line) > 0 {
+		_, _ = io.WriteString(rw, line+"\n")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInfluxLine(t *testing.T) {
+	now := time.Unix(1590000000, 0)
+	tests := []struct {
+		name     string
+		vars     map[string]string
+		expected string
+		skipped  []string
+	}{
+		{
+			name:     "numeric and text variables",
+			vars:     map[string]string{"battery.charge": "100", "ups.status": "OL CHRG", "ups.model": "Smart UPS", "ups.serial": "0123", "ups.firmware": "2.1", "driver.parameter.port": "auto"},
+			expected: `ups,server=nut,ups=ups,model=Smart\ UPS,serial=0123 battery.charge=100,ups.firmware="2.1",ups.model="Smart UPS",ups.serial="0123",ups.status="OL CHRG" 1590000000000000000`,
+			skipped:  []string{"driver.parameter.port"},
+		},
+		{
+			name:     "non finite values are skipped",
+			vars:     map[string]string{"battery.charge": "NaN", "battery.runtime": "+Inf", "ups.load": "12.5"},
+			expected: `ups,server=nut,ups=ups ups.load=12.5 1590000000000000000`,
+		},
+		{
+			name:     "quote in string field",
+			vars:     map[string]string{"ups.load": "1", "device.type": `u"ps\`},
+			expected: `ups,server=nut,ups=ups device.type="u\"ps\\",ups.load=1 1590000000000000000`,
+		},
+		{
+			name:     "without numeric value",
+			vars:     map[string]string{"ups.status": "OL", "battery.charge": "NaN"},
+			expected: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line, skipped := influxLine("ups", "nut", "ups", test.vars, now)
+			if line != test.expected {
+				t.Errorf("expected\n%s\nget\n%s", test.expected, line)
+			}
+			if !reflect.DeepEqual(skipped, test.skipped) {
+				t.Errorf("expected skipped variables %v get %v", test.skipped, skipped)
+			}
+		})
+	}
+}
+
+// influxServer record written bodies, first failures writes return status 503
+type influxServer struct {
+	*httptest.Server
+	bodies   chan string
+	mutex    sync.Mutex
+	failures int
+	count    int
+}
+
+func newInfluxServer(t *testing.T, failures int) *influxServer {
+	s := &influxServer{bodies: make(chan string, 10), failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "text/plain; charset=utf-8" || r.Header.Get("Authorization") != "Token secret" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mutex.Lock()
+		s.count++
+		fail := s.count <= s.failures
+		s.mutex.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		s.bodies <- string(body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *influxServer) requestCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.count
+}
+
+// lines return lines of next written body
+func (s *influxServer) lines(t *testing.T) []string {
+	t.Helper()
+	select {
+	case body := <-s.bodies:
+		return strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	case <-time.After(5 * time.Second):
+		t.Fatal("data aren't written")
+	}
+	return nil
+}
+
+func TestInfluxWriterBatchAndRetry(t *testing.T) {
+	s := newInfluxServer(t, 1)
+	w := newInfluxWriter(influxConfig{URL: s.URL, Token: "secret", BatchSize: 2, Retries: 1}, "nut", "ups")
+	start := time.Unix(1590000000, 0)
+	w.handleData("battery.charge: 100", start)
+	time.Sleep(50 * time.Millisecond)
+	if count := s.requestCount(); count != 0 {
+		t.Fatalf("not full batch is written, %d requests", count)
+	}
+	w.handleData("battery.charge: 99", start.Add(time.Second))
+	expected := []string{
+		"ups,server=nut,ups=ups battery.charge=100 1590000000000000000",
+		"ups,server=nut,ups=ups battery.charge=99 1590000001000000000",
+	}
+	if lines := s.lines(t); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected batch %q get %q", expected, lines)
+	}
+	if count := s.requestCount(); count != 2 {
+		t.Errorf("expected failed and retried request get %d requests", count)
+	}
+
+	// queued lines are written on close, data after close are ignored
+	w.handleData("battery.charge: 98", start.Add(2*time.Second))
+	w.close()
+	if lines := s.lines(t); len(lines) != 1 || lines[0] != "ups,server=nut,ups=ups battery.charge=98 1590000002000000000" {
+		t.Errorf("unexpected lines written on close %q", lines)
+	}
+	w.handleData("battery.charge: 97", start.Add(3*time.Second))
+	w.close()
+	time.Sleep(50 * time.Millisecond)
+	if count := s.requestCount(); count != 3 {
+		t.Errorf("data are written after close, %d requests", count)
+	}
+}
+
+// close stop retry and write failed batch once
+func TestInfluxWriterCloseStopRetry(t *testing.T) {
+	s := newInfluxServer(t, 10)
+	w := newInfluxWriter(influxConfig{URL: s.URL, Token: "secret", Retries: 10}, "nut", "ups")
+	w.handleData("battery.charge: 100", time.Now())
+	waitFor(t, func() bool { return s.requestCount() == 1 })
+	closed := make(chan struct{})
+	go func() {
+		w.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close wait for retry")
+	}
+	if count := s.requestCount(); count != 2 {
+		t.Errorf("expected one write on close get %d requests", count)
+	}
+}
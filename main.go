@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/promlog"
 	"github.com/prometheus/common/promlog/flag"
@@ -12,7 +11,6 @@ import (
 	"gopkg.in/alecthomas/kingpin.v2"
 	"net/http"
 	"os"
-	"time"
 )
 
 const (
@@ -21,6 +19,8 @@ const (
 )
 
 var (
+	serveCommand = kingpin.Command("serve", "Run exporter (default command).").Default()
+
 	logger    log.Logger // logger
 	Version   string
 	Revision  string
@@ -29,80 +29,18 @@ var (
 	BuildDate string
 )
 
-func updateStatus(output string) {
-	upsStatusValue := upsStatusRegex.FindAllStringSubmatch(output, -1)[0][1]
-
-	switch upsStatusValue {
-	case "CAL":
-		upsStatus.Set(0)
-	case "TRIM":
-		upsStatus.Set(1)
-	case "BOOST":
-		upsStatus.Set(2)
-	case "OL":
-		upsStatus.Set(3)
-	case "OB":
-		upsStatus.Set(4)
-	case "OVER":
-		upsStatus.Set(5)
-	case "LB":
-		upsStatus.Set(6)
-	case "RB":
-		upsStatus.Set(7)
-	case "BYPASS":
-		upsStatus.Set(8)
-	case "OFF":
-		upsStatus.Set(9)
-	case "CHRG":
-		upsStatus.Set(10)
-	case "DISCHRG":
-		upsStatus.Set(11)
-	}
-}
-
-func readVarList(conn connection) string {
+func readVarList(conn connection) (string, error) {
 	err := conn.open()
 	if err != nil {
-		return ""
+		return "", err
 	}
 	defer conn.close()
 	data, err := conn.getList("VAR")
 	if err != nil {
 		_ = level.Error(logger).Log("msg", err)
-		return ""
-	}
-	return data
-}
-
-func recordMetrics() {
-	for _, metric := range metricsList {
-		prometheus.MustRegister(metric.metrics)
-	}
-	for _, metric := range metricsVecList {
-		prometheus.MustRegister(metric.metrics)
+		return "", err
 	}
-	prometheus.MustRegister(upsStatus)
-	_ = level.Debug(logger).Log("msg", "create connection for NUT server", "host", config.getServer())
-	connection := *newConnection(config.getServer(), config.User, config.Password, config.UpsName)
-
-	go func() {
-		for {
-			upsOutput := readVarList(connection)
-
-			if len(upsOutput) == 0 {
-				_ = level.Error(logger).Log("msg", "problem read data from NUT server")
-			} else {
-				for _, metric := range metricsList {
-					metric.updateFromSource(upsOutput)
-				}
-				for _, metric := range metricsVecList {
-					metric.updateFromSource(upsOutput)
-				}
-				updateStatus(upsOutput)
-			}
-			time.Sleep(time.Duration(config.Refresh) * time.Second)
-		}
-	}()
+	return data, nil
 }
 
 func main() {
@@ -115,8 +53,34 @@ func main() {
 	version.Version = Version
 	kingpin.Version(version.Print(applicationName))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
 	logger = promlog.New(promlogConfig)
+
+	switch command {
+	case checkCommand.FullCommand():
+		os.Exit(checkConfig(*configFile))
+	case diagnoseCommand.FullCommand():
+		if err := config.loadFile(*configFile); err != nil {
+			fmt.Printf("Configuration error: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(diagnose(config))
+	case replayCommand.FullCommand():
+		os.Exit(replay())
+	case simulateCommand.FullCommand():
+		os.Exit(simulate())
+	case queryListUps.FullCommand(), queryVars.FullCommand(), queryGet.FullCommand(), queryCmds.FullCommand(), queryClients.FullCommand():
+		// query don't login like upsc, user and password aren't required
+		if err := config.load(*configFile, true); err != nil {
+			fmt.Fprintf(os.Stderr, "Configuration error: %s\n", err)
+			os.Exit(1)
+		}
+		if err := runQuery(config, command); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	_ = level.Info(logger).Log("msg", "Starting NUT exporter on ups "+config.UpsName, "version", version.Info())
 
 	err := config.loadFile(*configFile)
@@ -134,9 +98,46 @@ func main() {
 
 	_ = level.Info(logger).Log("msg", "Build context", "build_context", version.BuildContext())
 	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/", statusPageHandler)
+	http.HandleFunc("/-/healthy", healthyHandler)
+	http.HandleFunc("/-/ready", readyHandler)
+	http.HandleFunc("/api/v1/ups", upsListHandler)
+	http.HandleFunc("/api/v1/ups/", upsHandler)
+	http.HandleFunc("/api/v1/events", eventsHandler)
+	http.HandleFunc("/influx", influxHandler)
+	http.HandleFunc("/-/reload", reloadHandler)
 
-	recordMetrics()
+	if len(*recordFile) > 0 {
+		activeRecorder, err = newRecorder(*recordFile)
+		if err != nil {
+			_ = level.Error(logger).Log("msg", "problem open record file", "file", *recordFile, "error", err)
+			os.Exit(1)
+		}
+		dialNut = activeRecorder.dial
+		_ = level.Warn(logger).Log("msg", "NUT protocol exchanges are recorded", "file", *recordFile)
+	}
+	registerMetrics()
+	if err = startPoller(config); err != nil {
+		_ = level.Error(logger).Log("msg", "problem start polling NUT server", "error", err)
+		os.Exit(1)
+	}
 
-	_ = level.Info(logger).Log("msg", "Listening on", "address", *listenAddress)
-	_ = http.ListenAndServe(*listenAddress, nil)
+	webConfig := webConfigData{}
+	if len(*webConfigFile) > 0 {
+		webConfig, err = loadWebConfig(*webConfigFile)
+		if err != nil {
+			_ = level.Error(logger).Log("msg", "problem with web configuration", "file", *webConfigFile, "error", err)
+			os.Exit(1)
+		}
+	}
+	webServerActive, err = newWebServer(webConfig)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "problem load TLS certificates", "error", err)
+		os.Exit(1)
+	}
+	watchReloadSignal()
+	_ = level.Info(logger).Log("msg", "Listening on", "address", *listenAddress, "tls", webConfig.tlsEnabled())
+	err = webServerActive.listen(*listenAddress)
+	_ = level.Error(logger).Log("msg", "HTTP server stopped", "error", err)
+	os.Exit(1)
 }
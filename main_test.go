@@ -0,0 +1,12 @@
+package main
+
+import (
+	"github.com/go-kit/kit/log"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger = log.NewNopLogger()
+	os.Exit(m.Run())
+}
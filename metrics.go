@@ -1,269 +1,396 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"math"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 )
 
-type metricsGauge struct {
-	metrics  prometheus.Gauge
-	analyzer *regexp.Regexp
-}
+const (
+	metricTypeGauge = "gauge" // numeric value of variable multiplied by scale
+	metricTypeInfo  = "info"  // value 1, variable value is in label
+	metricTypeEnum  = "enum"  // value for first word of variable from values
+)
 
-type metricsGaugeVec struct {
-	metrics  *prometheus.GaugeVec
-	analyzer *regexp.Regexp
-	name     string
-}
+// NUT variables https://networkupstools.org/docs/user-manual.chunked/apcs01.html
+const defaultMetricMappings = `
+metrics:
+  - name: battery_charge
+    variable: battery.charge
+    help: Current battery charge (percent)
+  - name: battery_charge_low
+    variable: battery.charge.low
+    help: Remaining battery level when UPS switches to LB state (percent)
+  - name: battery_charge_warning
+    variable: battery.charge.warning
+    help: Battery level when UPS switches to "Warning" state (percent)
+  - name: battery_pack
+    variable: battery.packs
+    help: Number of battery packs on the UPS
+  - name: battery_type
+    variable: battery.type
+    help: Battery chemistry
+    type: info
+    valueLabel: type
+  - name: battery_voltage
+    variable: battery.voltage
+    help: Current battery voltage
+  - name: battery_voltage_nominal
+    variable: battery.voltage.nominal
+    help: Nominal battery voltage
+  - name: device_mfr
+    variable: device.mfr
+    help: Device manufacturer
+    type: info
+    valueLabel: manufacturer
+  - name: device_model
+    variable: device.model
+    help: Device model
+    type: info
+    valueLabel: model
+  - name: device_type
+    variable: device.type
+    help: Device type (ups, pdu, scd, psu, ats)
+    type: info
+    valueLabel: type
+  - name: driver_name
+    variable: driver.name
+    help: Driver name
+    type: info
+    valueLabel: name
+  - name: driver_version
+    variable: driver.version
+    help: Driver version (NUT release)
+    type: info
+    valueLabel: version
+  - name: driver_version_data
+    variable: driver.version.data
+    help: Version of the internal data mapping, for generic drivers
+    type: info
+    valueLabel: data
+  - name: input_voltage
+    variable: input.voltage
+    help: Current input voltage
+  - name: input_voltage_nominal
+    variable: input.voltage.nominal
+    help: Nominal input voltage
+  - name: output_voltage
+    variable: output.voltage
+    help: Current output voltage
+  - name: output_voltage_nominal
+    variable: output.voltage.nominal
+    help: Nominal output voltage
+  - name: ups_beeper_status
+    variable: ups.beeper.status
+    help: UPS beeper status (enabled, disabled or muted)
+    type: info
+    valueLabel: status
+  - name: ups_delay_shutdown
+    variable: ups.delay.shutdown
+    help: Interval to wait after shutdown with delay command (seconds)
+  - name: ups_delay_start
+    variable: ups.delay.start
+    help: Interval to wait before restarting the load (seconds)
+  - name: ups_load
+    variable: ups.load
+    help: Current UPS load (percent)
+  - name: ups_mfr
+    variable: ups.mfr
+    help: UPS manufacturer
+    type: info
+    valueLabel: manufacturer
+  - name: ups_model
+    variable: ups.model
+    help: UPS model
+    type: info
+    valueLabel: model
+  - name: ups_power_nominal
+    variable: ups.power.nominal
+    help: Nominal value of apparent power (Volt-Amps)
+  - name: ups_real_power_nominal
+    variable: ups.realpower.nominal
+    help: Nominal value of real power (Watts)
+  - name: ups_temp
+    variable: ups.temperature
+    help: UPS Temperature (degrees C)
+  - name: ups_status
+    variable: ups.status
+    help: Current UPS Status (0=Calibration, 1=SmartTrim, 2=SmartBoost, 3=Online, 4=OnBattery, 5=Overloaded, 6=LowBattery, 7=ReplaceBattery, 8=OnBypass, 9=Off, 10=Charging, 11=Discharging)
+    type: enum
+    values: {CAL: 0, TRIM: 1, BOOST: 2, OL: 3, OB: 4, OVER: 5, LB: 6, RB: 7, BYPASS: 8, OFF: 9, CHRG: 10, DISCHRG: 11}
+`
 
-//type metricFunc interface {
-//	updateFromSource(output string)
-//}
-//
-// Regex
 var (
-	batteryChargeRegex         = regexp.MustCompile(`(?:battery[.]charge:(?:\s)(.*))`)
-	batteryChargeLowRegex      = regexp.MustCompile(`(?:battery[.]charge[.]low:(?:\s)(.*))`)
-	batteryChargeWarnRegex     = regexp.MustCompile(`(?:battery[.]charge[.]warning:(?:\s)(.*))`)
-	batteryPacksRegex          = regexp.MustCompile(`(?:battery[.]packs:(?:\s)(.*))`)
-	batteryTypeRegex           = regexp.MustCompile(`(?:battery[.]type:(?:\s)(.*))`)
-	batteryVoltageRegex        = regexp.MustCompile(`(?:battery[.]voltage:(?:\s)(.*))`)
-	batteryVoltageNominalRegex = regexp.MustCompile(`(?:battery[.]voltage[.]nominal:(?:\s)(.*))`)
-	deviceMFRRegex             = regexp.MustCompile(`(?:device[.]mfr:(?:\s)(.*))`)
-	deviceModelRegex           = regexp.MustCompile(`(?:device[.]model:(?:\s)(.*))`)
-	deviceTypeRegex            = regexp.MustCompile(`(?:device[.]type:(?:\s)(.*))`)
-	driverNameRegex            = regexp.MustCompile(`(?:driver[.]name:(?:\s)(.*))`)
-	//driverPoolFreqRegex        = regexp.MustCompile(`(?:driver[.]parameter[.]poolfreq:(?:\s)(.*))`)
-	//driverPoolIntervalRegex    = regexp.MustCompile(`(?:driver[.]parameter[.]poolinterval:(?:\s)(.*))`)
-	driverVersionRegex        = regexp.MustCompile(`(?:driver[.]version:(?:\s)(.*))`)
-	driverVersionDataRegex    = regexp.MustCompile(`(?:driver[.]version[.]data:(?:\s)(.*))`)
-	inputVoltageRegex         = regexp.MustCompile(`(?:input[.]voltage:(?:\s)(.*))`)
-	inputVoltageNominalRegex  = regexp.MustCompile(`(?:input[.]voltage[.]nominal:(?:\s)(.*))`)
-	outputVoltageRegex        = regexp.MustCompile(`(?:output[.]voltage:(?:\s)(.*))`)
-	outputVoltageNominalRegex = regexp.MustCompile(`(?:output[.]voltage[.]nominal:(?:\s)(.*))`)
-	upsBeeperStatusRegex      = regexp.MustCompile(`(?:ups[.]beeper[.]status:(?:\s)(.*))`)
-	upsDelayShutRegex         = regexp.MustCompile(`(?:ups[.]delay[.]shutdown:(?:\s)(.*))`)
-	upsDelayStartRegex        = regexp.MustCompile(`(?:ups[.]delay[.]start:(?:\s)(.*))`)
-	upsLoadRegex              = regexp.MustCompile(`(?:ups[.]load:(?:\s)(.*))`)
-	upsMFRRegex               = regexp.MustCompile(`(?:ups[.]mfr:(?:\s)(.*))`)
-	upsModelRegex             = regexp.MustCompile(`(?:ups[.]model:(?:\s)(.*))`)
-	upsPowerNominalRegex      = regexp.MustCompile(`(?:ups[.]power[.]nominal:(?:\s)(.*))`)
-	upsRealPowerNominalRegex  = regexp.MustCompile(`(?:ups[.]realpower[.]nominal:(?:\s)(.*))`)
-	upsTempRegex              = regexp.MustCompile(`(?:ups[.]temperature:(?:\s)(.*))`)
-	upsStatusRegex            = regexp.MustCompile(`(?:ups[.]status:(?:\s)(.*))`)
+	metricNameRegex = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegex  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	nutMetrics = &mappingCollector{}
+
+	// names of metrics created by exporter itself, mapping can't use them
+	reservedMetricNames = []string{
+		"ups_status_transitions_total",
+		"ups_status_last_change_timestamp_seconds",
+		"ups_on_battery_duration_seconds",
+		"ups_on_battery_duration_seconds_bucket",
+		"ups_on_battery_duration_seconds_sum",
+		"ups_on_battery_duration_seconds_count",
+	}
+	reservedMetricPrefix = "config_"
 )
 
-// NUT Gauges https://networkupstools.org/docs/user-manual.chunked/apcs01.html
-var (
-	batteryCharge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "battery_charge",
-		Help:      "Current battery charge (percent)",
-	})
-
-	batteryChargeLow = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "battery_charge_low",
-		Help:      "Remaining battery level when UPS switches to LB state (percent)",
-	})
-
-	batteryChargeWarning = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "battery_charge_warning",
-		Help:      "Battery level when UPS switches to \"Warning\" state (percent)",
-	})
-
-	batteryPacks = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "battery_pack",
-		Help:      "Number of battery packs on the UPS",
-	})
-
-	batteryType = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "battery_type",
-		Help:      "Battery chemistry",
-	}, []string{"type"})
-
-	batteryVoltage = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "battery_voltage",
-		Help:      "Current battery voltage",
-	})
-
-	batteryVoltageNominal = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "battery_voltage_nominal",
-		Help:      "Nominal battery voltage",
-	})
-
-	deviceMfr = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "device_mfr",
-		Help:      "Device manufacturer",
-	}, []string{"manufacturer"})
-
-	deviceModel = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "device_model",
-		Help:      "Device model",
-	}, []string{"model"})
-
-	deviceType = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "device_type",
-		Help:      "Device type (ups, pdu, scd, psu, ats)",
-	}, []string{"type"})
-
-	driverName = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "driver_name",
-		Help:      "Driver name",
-	}, []string{"name"})
-
-	driverVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "driver_version",
-		Help:      "Driver version (NUT release)",
-	}, []string{"version"})
-
-	driverVersionData = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "driver_version_data",
-		Help:      "Version of the internal data mapping, for generic drivers",
-	}, []string{"data"})
-
-	inputVoltage = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "input_voltage",
-		Help:      "Current input voltage",
-	})
-
-	inputVoltageNominal = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "input_voltage_nominal",
-		Help:      "Nominal input voltage",
-	})
-
-	outputVoltage = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "output_voltage",
-		Help:      "Current output voltage",
-	})
-
-	outputVoltageNominal = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "output_voltage_nominal",
-		Help:      "Nominal output voltage",
-	})
-
-	upsBeeperStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "ups_beeper_status",
-		Help:      "UPS beeper status (enabled, disabled or muted)",
-	}, []string{"status"})
-
-	upsDelayShut = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "ups_delay_shutdown",
-		Help:      "Interval to wait after shutdown with delay command (seconds)",
-	})
-
-	upsDelayStart = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "ups_delay_start",
-		Help:      "Interval to wait before restarting the load (seconds)",
-	})
-
-	upsLoad = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "ups_load",
-		Help:      "Current UPS load (percent)",
-	})
-
-	upsMfr = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "ups_mfr",
-		Help:      "UPS manufacturer",
-	}, []string{"manufacturer"})
+type metricMapping struct {
+	Name       string             `yaml:"name"`
+	Variable   string             `yaml:"variable"`
+	Pattern    string             `yaml:"pattern"`
+	Help       string             `yaml:"help"`
+	Type       string             `yaml:"type"`
+	Scale      float64            `yaml:"scale"`
+	Labels     []string           `yaml:"labels"`
+	ValueLabel string             `yaml:"valueLabel"`
+	Values     map[string]float64 `yaml:"values"`
+	Disabled   bool               `yaml:"disabled"`
+	regex      *regexp.Regexp
+	desc       *prometheus.Desc
+}
 
-	upsModel = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "ups_model",
-		Help:      "UPS model",
-	}, []string{"model"})
+type metricMappingFile struct {
+	ReplaceDefault bool            `yaml:"replaceDefault"`
+	Metrics        []metricMapping `yaml:"metrics"`
+}
 
-	upsPowerNominal = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "ups_power_nominal",
-		Help:      "Nominal value of apparent power (Volt-Amps)",
-	})
+// mappingCollector expose metrics created from last output of LIST VAR
+type mappingCollector struct {
+	metrics []prometheus.Metric
+	mutex   sync.RWMutex
+}
 
-	upsRealPowerNominal = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "ups_real_power_nominal",
-		Help:      "Nominal value of real power (Watts)",
-	})
+// compile validate mapping and prepare regex for pattern and metric description
+func (m *metricMapping) compile() error {
+	if !metricNameRegex.MatchString(m.Name) {
+		return fmt.Errorf("metric name [%s] isn't valid", m.Name)
+	}
+	if strings.HasPrefix(m.Name, reservedMetricPrefix) {
+		return fmt.Errorf("metric name [%s] is reserved, prefix %s is used by exporter", m.Name, reservedMetricPrefix)
+	}
+	for _, reserved := range reservedMetricNames {
+		if m.Name == reserved {
+			return fmt.Errorf("metric name [%s] is reserved for status metric", m.Name)
+		}
+	}
+	if (len(m.Variable) == 0) == (len(m.Pattern) == 0) {
+		return fmt.Errorf("metric [%s] must have variable or pattern", m.Name)
+	}
+	pattern := regexp.QuoteMeta(m.Variable)
+	if len(m.Pattern) > 0 {
+		pattern = m.Pattern
+	}
+	var err error
+	if m.regex, err = regexp.Compile("^(?:" + pattern + ")$"); err != nil {
+		return fmt.Errorf("metric [%s] pattern isn't valid: %s", m.Name, err)
+	}
+	if m.regex.NumSubexp() != len(m.Labels) {
+		return fmt.Errorf("metric [%s] has %d labels but pattern has %d groups", m.Name, len(m.Labels), m.regex.NumSubexp())
+	}
+	labels := m.Labels
+	switch m.Type {
+	case "":
+		m.Type = metricTypeGauge
+	case metricTypeGauge:
+	case metricTypeInfo:
+		if len(m.ValueLabel) == 0 {
+			return fmt.Errorf("metric [%s] of type info must have valueLabel", m.Name)
+		}
+		for _, label := range m.Labels {
+			if label == m.ValueLabel {
+				return fmt.Errorf("metric [%s] valueLabel [%s] is also in labels", m.Name, m.ValueLabel)
+			}
+		}
+		labels = append(append([]string{}, m.Labels...), m.ValueLabel)
+	case metricTypeEnum:
+		if len(m.Values) == 0 {
+			return fmt.Errorf("metric [%s] of type enum must have values", m.Name)
+		}
+	default:
+		return fmt.Errorf("metric [%s] has unknown type [%s] (gauge, info, enum)", m.Name, m.Type)
+	}
+	names := map[string]bool{}
+	for _, label := range labels {
+		if !labelNameRegex.MatchString(label) {
+			return fmt.Errorf("metric [%s] label name [%s] isn't valid", m.Name, label)
+		}
+		if names[label] {
+			return fmt.Errorf("metric [%s] label [%s] is defined more times", m.Name, label)
+		}
+		names[label] = true
+	}
+	if m.Scale == 0 {
+		m.Scale = 1
+	}
+	m.desc = prometheus.NewDesc(nameSpace+"_"+m.Name, m.Help, labels, nil)
+	return nil
+}
 
-	upsTemp = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "ups_temp",
-		Help:      "UPS Temperature (degrees C)",
-	})
+// value return value of metric for variable value
+func (m *metricMapping) value(value string) (float64, bool) {
+	switch m.Type {
+	case metricTypeInfo:
+		return 1, true
+	case metricTypeEnum:
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return 0, false
+		}
+		found, ok := m.Values[fields[0]]
+		return found, ok
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, false
+	}
+	return number * m.Scale, true
+}
 
-	upsStatus = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: nameSpace,
-		Name:      "ups_status",
-		Help:      "Current UPS Status (0=Calibration, 1=SmartTrim, 2=SmartBoost, 3=Online, 4=OnBattery, 5=Overloaded, 6=LowBattery, 7=ReplaceBattery, 8=OnBypass, 9=Off, 10=Charging, 11=Discharging)",
-	})
-)
+func parseMetricMappings(content []byte) (metricMappingFile, error) {
+	mappings := metricMappingFile{}
+	err := unmarshalStrict(content, &mappings)
+	return mappings, err
+}
 
-var metricsList = []metricsGauge{
-	{batteryCharge, batteryChargeRegex},
-	{batteryChargeLow, batteryChargeLowRegex},
-	{batteryChargeWarning, batteryChargeWarnRegex},
-	{batteryPacks, batteryPacksRegex},
-	{batteryVoltage, batteryVoltageRegex},
-	{batteryVoltageNominal, batteryVoltageNominalRegex},
-	{inputVoltage, inputVoltageRegex},
-	{inputVoltageNominal, inputVoltageNominalRegex},
-	{outputVoltage, outputVoltageRegex},
-	{outputVoltageNominal, outputVoltageNominalRegex},
-	{upsDelayShut, upsDelayShutRegex},
-	{upsDelayStart, upsDelayStartRegex},
-	{upsLoad, upsLoadRegex},
-	{upsPowerNominal, upsPowerNominalRegex},
-	{upsRealPowerNominal, upsRealPowerNominalRegex},
-	{upsTemp, upsTempRegex},
+// loadMetricMappings return built-in mapping with mapping from file, entry with same name replace
+// built-in entry, disabled entry remove it and other entries are added
+func loadMetricMappings(filename string) ([]metricMapping, error) {
+	defaults, err := parseMetricMappings([]byte(defaultMetricMappings))
+	if err != nil {
+		return nil, err
+	}
+	mappings := defaults.Metrics
+	if len(filename) > 0 {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		custom, err := parseMetricMappings(content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		if custom.ReplaceDefault {
+			mappings = nil
+		}
+		names := map[string]bool{}
+		for _, mapping := range custom.Metrics {
+			if names[mapping.Name] {
+				return nil, fmt.Errorf("%s: metric [%s] is defined more times", filename, mapping.Name)
+			}
+			names[mapping.Name] = true
+			replaced := false
+			for i := range mappings {
+				if mappings[i].Name == mapping.Name {
+					mappings[i] = mapping
+					replaced = true
+				}
+			}
+			if !replaced {
+				mappings = append(mappings, mapping)
+			}
+		}
+	}
+	var active []metricMapping
+	names := map[string]bool{}
+	for _, mapping := range mappings {
+		if mapping.Disabled {
+			continue
+		}
+		if names[mapping.Name] {
+			return nil, fmt.Errorf("metric [%s] is defined more times", mapping.Name)
+		}
+		names[mapping.Name] = true
+		if err = mapping.compile(); err != nil {
+			return nil, err
+		}
+		active = append(active, mapping)
+	}
+	if len(active) == 0 {
+		return nil, errors.New("no metric is defined in mapping")
+	}
+	return active, nil
 }
-var metricsVecList = []metricsGaugeVec{
-	{batteryType, batteryTypeRegex, "type"},
-	{deviceMfr, deviceMFRRegex, "manufacturer"},
-	{deviceModel, deviceModelRegex, "model"},
-	{deviceType, deviceTypeRegex, "type"},
-	{driverName, driverNameRegex, "name"},
-	{driverVersion, driverVersionRegex, "version"},
-	{driverVersionData, driverVersionDataRegex, "data"},
-	{upsBeeperStatus, upsBeeperStatusRegex, "status"},
-	{upsMfr, upsMFRRegex, "manufacturer"},
-	{upsModel, upsModelRegex, "model"},
+
+// Describe send nothing, metrics depend on variables returned by NUT server and on reloaded mapping;
+// unchecked collector can't detect name collision, so mapping names are checked by compile and loadMetricMappings
+func (c *mappingCollector) Describe(chan<- *prometheus.Desc) {
 }
 
-func (gauge *metricsGauge) updateFromSource(output string) {
-	if gauge.analyzer.FindAllStringSubmatch(output, -1) == nil {
-		prometheus.Unregister(gauge.metrics)
-	} else {
-		getData, _ := strconv.ParseFloat(gauge.analyzer.FindAllStringSubmatch(output, -1)[0][1], 64)
-		gauge.metrics.Set(getData)
+func (c *mappingCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, metric := range c.metrics {
+		ch <- metric
 	}
 }
 
-func (gaugeVec *metricsGaugeVec) updateFromSource(output string) {
-	if gaugeVec.analyzer.FindAllStringSubmatch(output, -1) == nil {
-		prometheus.Unregister(gaugeVec.metrics)
-	} else {
-		getData := gaugeVec.analyzer.FindAllStringSubmatch(output, -1)[0][1]
-		gaugeVec.metrics.With(prometheus.Labels{gaugeVec.name: getData}).Set(1)
+// update create metrics from output of LIST VAR, metric for missing variable isn't exposed,
+// variables and metrics not allowed by filter are skipped before metric is created
+func (c *mappingCollector) update(mappings []metricMapping, filter *metricFilter, output string) {
+	variables := parseVarList(output)
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		if filter.variableAllowed(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var metrics []prometheus.Metric
+	for _, mapping := range mappings {
+		if !filter.metricAllowed(nameSpace + "_" + mapping.Name) {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, name := range names {
+			found := mapping.regex.FindStringSubmatch(name)
+			if found == nil {
+				continue
+			}
+			value, ok := mapping.value(variables[name])
+			if !ok {
+				_ = level.Debug(logger).Log("msg", "variable value isn't valid for metric", "variable", name, "value", variables[name], "metric", mapping.Name)
+				continue
+			}
+			labels := found[1:]
+			if mapping.Type == metricTypeInfo {
+				labels = append(labels, variables[name])
+			}
+			key := strings.Join(labels, "\xff")
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			metric, err := prometheus.NewConstMetric(mapping.desc, prometheus.GaugeValue, value, labels...)
+			if err != nil {
+				_ = level.Warn(logger).Log("msg", "problem create metric", "metric", mapping.Name, "error", err)
+				continue
+			}
+			metrics = append(metrics, metric)
+		}
 	}
+	c.mutex.Lock()
+	c.metrics = metrics
+	c.mutex.Unlock()
+}
+
+// isFinite return false for NaN and Inf, such values can't be exported to JSON or line protocol
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+// registerDataMetrics register metrics with values of UPS variables
+func registerDataMetrics() {
+	prometheus.MustRegister(nutMetrics)
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFile create file in temporary directory, directory is removed at end of test
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "nut_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	filename := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadMetricMappings(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping string
+		err     string
+	}{
+		{
+			name:    "new metric",
+			mapping: "metrics:\n  - name: battery_runtime\n    variable: battery.runtime\n",
+		},
+		{
+			name:    "override built-in metric",
+			mapping: "metrics:\n  - name: ups_load\n    variable: ups.load\n    scale: 0.01\n",
+		},
+		{
+			name:    "status transitions metric",
+			mapping: "metrics:\n  - name: ups_status_transitions_total\n    variable: ups.status\n",
+			err:     "is reserved",
+		},
+		{
+			name:    "status last change metric",
+			mapping: "metrics:\n  - name: ups_status_last_change_timestamp_seconds\n    variable: ups.status\n",
+			err:     "is reserved",
+		},
+		{
+			name:    "on battery histogram",
+			mapping: "metrics:\n  - name: ups_on_battery_duration_seconds_count\n    variable: ups.status\n",
+			err:     "is reserved",
+		},
+		{
+			name:    "config prefix",
+			mapping: "metrics:\n  - name: config_reload\n    variable: ups.status\n",
+			err:     "is reserved",
+		},
+		{
+			name:    "duplicate name",
+			mapping: "replaceDefault: true\nmetrics:\n  - name: load\n    variable: ups.load\n  - name: load\n    variable: ups.realpower\n",
+			err:     "defined more times",
+		},
+		{
+			name:    "valueLabel in labels",
+			mapping: "metrics:\n  - name: outlet_status\n    pattern: 'outlet\\.(\\d+)\\.status'\n    labels: [status]\n    type: info\n    valueLabel: status\n",
+			err:     "valueLabel [status] is also in labels",
+		},
+		{
+			name:    "duplicate label",
+			mapping: "metrics:\n  - name: outlet_value\n    pattern: 'outlet\\.(\\d+)\\.(\\d+)'\n    labels: [outlet, outlet]\n",
+			err:     "label [outlet] is defined more times",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadMetricMappings(writeTestFile(t, "metrics.yml", test.mapping))
+			switch {
+			case len(test.err) == 0 && err != nil:
+				t.Errorf("unexpected error %s", err)
+			case len(test.err) > 0 && err == nil:
+				t.Errorf("expected error %q", test.err)
+			case len(test.err) > 0 && !strings.Contains(err.Error(), test.err):
+				t.Errorf("expected error %q get %q", test.err, err)
+			}
+		})
+	}
+}
+
+func TestMetricMappingValue(t *testing.T) {
+	mappings, err := loadMetricMappings(writeTestFile(t, "metrics.yml", `metrics:
+  - name: ups_load_ratio
+    variable: ups.load
+    scale: 0.01
+  - name: ups_firmware
+    variable: ups.firmware
+    type: info
+    valueLabel: firmware
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]metricMapping{}
+	for _, mapping := range mappings {
+		byName[mapping.Name] = mapping
+	}
+	tests := []struct {
+		metric string
+		value  string
+		result float64
+		ok     bool
+	}{
+		{metric: "ups_load_ratio", value: "42", result: 0.42, ok: true},
+		{metric: "ups_load_ratio", value: " 50 ", result: 0.5, ok: true},
+		{metric: "ups_load_ratio", value: "unknown"},
+		{metric: "ups_firmware", value: "UPS 09.3", result: 1, ok: true},
+		// enum value is read from first flag of multi-flag status
+		{metric: "ups_status", value: "OL", result: 3, ok: true},
+		{metric: "ups_status", value: "OL CHRG", result: 3, ok: true},
+		{metric: "ups_status", value: "OB DISCHRG LB", result: 4, ok: true},
+		{metric: "ups_status", value: "UNKNOWN OL"},
+		{metric: "ups_status", value: ""},
+	}
+	for _, test := range tests {
+		mapping := byName[test.metric]
+		if result, ok := mapping.value(test.value); result != test.result || ok != test.ok {
+			t.Errorf("%s %q: expected %v %v get %v %v", test.metric, test.value, test.result, test.ok, result, ok)
+		}
+	}
+}
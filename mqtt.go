@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/go-kit/kit/log/level"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mqttOnline  = "online"
+	mqttOffline = "offline"
+)
+
+type mqttConfig struct {
+	Broker             string `yaml:"broker" json:"broker"`
+	ClientID           string `yaml:"clientId" json:"clientId"`
+	User               string `yaml:"user" json:"user"`
+	Password           string `yaml:"password" json:"password"`
+	Topic              string `yaml:"topic" json:"topic"`
+	QoS                byte   `yaml:"qos" json:"qos"`
+	Retain             bool   `yaml:"retain" json:"retain"`
+	CAFile             string `yaml:"caFile" json:"caFile"`
+	CertFile           string `yaml:"certFile" json:"certFile"`
+	KeyFile            string `yaml:"keyFile" json:"keyFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify" json:"insecureSkipVerify"`
+	HomeAssistant      bool   `yaml:"homeAssistant" json:"homeAssistant"`
+	DiscoveryPrefix    string `yaml:"discoveryPrefix" json:"discoveryPrefix"`
+}
+
+type mqttPublisher struct {
+	config     mqttConfig
+	client     mqtt.Client
+	server     string
+	ups        string
+	clientID   string
+	prefix     string
+	discovered map[string]bool
+	closed     bool
+	mutex      sync.Mutex
+}
+
+type mqttState struct {
+	Time      time.Time         `json:"time"`
+	Ups       string            `json:"ups"`
+	Flags     []string          `json:"flags"`
+	Variables map[string]string `json:"variables"`
+}
+
+func (m *mqttConfig) enabled() bool {
+	return len(m.Broker) > 0
+}
+
+func (m *mqttConfig) validate() []error {
+	if !m.enabled() {
+		return nil
+	}
+	var errs []error
+	if !strings.HasPrefix(m.Broker, "tcp://") && !strings.HasPrefix(m.Broker, "ssl://") &&
+		!strings.HasPrefix(m.Broker, "ws://") && !strings.HasPrefix(m.Broker, "wss://") {
+		errs = append(errs, errors.New("MQTT broker must be URL with scheme tcp://, ssl://, ws:// or wss://"))
+	}
+	if m.QoS > 2 {
+		errs = append(errs, errors.New("MQTT QoS is out of range (0-2)"))
+	}
+	if strings.ContainsAny(m.Topic, "+#") {
+		errs = append(errs, errors.New("MQTT topic can't contain wildcards"))
+	}
+	if (len(m.CertFile) > 0) != (len(m.KeyFile) > 0) {
+		errs = append(errs, errors.New("MQTT client certificate and key must be defined together"))
+	}
+	return errs
+}
+
+func (m *mqttConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: m.InsecureSkipVerify}
+	if len(m.CAFile) > 0 {
+		content, err := ioutil.ReadFile(m.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(content) {
+			return nil, errors.New("no valid certificate in MQTT CA file " + m.CAFile)
+		}
+	}
+	if len(m.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(m.CertFile, m.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newMqttPublisher create publisher for broker, topics are <topic>/<server>/<ups>/..., publisher must be connected by connect
+func newMqttPublisher(c mqttConfig, server, ups string) (*mqttPublisher, error) {
+	topic := c.Topic
+	if len(topic) == 0 {
+		topic = "nut"
+	}
+	clientID := c.ClientID
+	if len(clientID) == 0 {
+		clientID = fmt.Sprintf("%s_%s_%s", applicationName, server, ups)
+	}
+	publisher := &mqttPublisher{config: c, server: server, ups: ups, clientID: clientID, discovered: map[string]bool{}, prefix: fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(topic, "/"), server, ups)}
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	options := mqtt.NewClientOptions().
+		AddBroker(c.Broker).
+		SetClientID(clientID).
+		SetUsername(c.User).
+		SetPassword(c.Password).
+		SetTLSConfig(tlsConfig).
+		SetAutoReconnect(true).
+		SetWill(publisher.topic("availability"), mqttOffline, c.QoS, true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			_ = level.Info(logger).Log("msg", "connected to MQTT broker", "broker", c.Broker)
+			client.Publish(publisher.topic("availability"), c.QoS, true, mqttOnline)
+			publisher.mutex.Lock()
+			publisher.discovered = map[string]bool{}
+			publisher.mutex.Unlock()
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			_ = level.Warn(logger).Log("msg", "connection to MQTT broker lost", "broker", c.Broker, "error", err)
+		})
+	publisher.client = mqtt.NewClient(options)
+	return publisher, nil
+}
+
+// connect to broker, publisher can connect again after close
+func (p *mqttPublisher) connect() error {
+	token := p.client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		return errors.New("timeout connect to MQTT broker " + p.config.Broker)
+	}
+	if token.Error() != nil {
+		return token.Error()
+	}
+	p.mutex.Lock()
+	p.closed = false
+	p.mutex.Unlock()
+	return nil
+}
+
+// reusable return true when publisher for new configuration would be same
+func (p *mqttPublisher) reusable(c mqttConfig, server, ups string) bool {
+	return reflect.DeepEqual(p.config, c) && p.server == server && p.ups == ups
+}
+
+// conflicts return true when publisher for new configuration use same broker with same client ID or topics,
+// broker disconnect one of clients with same ID and offline message of closed publisher overwrite availability
+func (p *mqttPublisher) conflicts(other *mqttPublisher) bool {
+	return p.config.Broker == other.config.Broker && (p.clientID == other.clientID || p.prefix == other.prefix)
+}
+
+func (p *mqttPublisher) topic(name string) string {
+	return p.prefix + "/" + name
+}
+
+func (p *mqttPublisher) publish(topic string, retain bool, payload interface{}) {
+	token := p.client.Publish(topic, p.config.QoS, retain, payload)
+	go func() {
+		if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+			_ = level.Error(logger).Log("msg", "problem publish to MQTT broker", "topic", topic, "error", token.Error())
+		}
+	}()
+}
+
+func (p *mqttPublisher) handleData(output string, now time.Time) {
+	vars := parseVarList(output)
+	if p.config.HomeAssistant {
+		p.mutex.Lock()
+		p.publishDiscovery(vars)
+		p.mutex.Unlock()
+	}
+	for name, value := range vars {
+		p.publish(p.topic(name), p.config.Retain, value)
+	}
+	state, err := json.Marshal(mqttState{now, p.ups, strings.Fields(vars["ups.status"]), vars})
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "problem create MQTT state", "error", err)
+		return
+	}
+	p.publish(p.topic("state"), p.config.Retain, state)
+}
+
+func (p *mqttPublisher) handleEvents(events []upsEvent) {
+	for _, event := range events {
+		content, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		p.publish(p.topic("event"), false, content)
+	}
+}
+
+func (p *mqttPublisher) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	token := p.client.Publish(p.topic("availability"), p.config.QoS, true, mqttOffline)
+	token.WaitTimeout(5 * time.Second)
+	p.client.Disconnect(1000)
+}
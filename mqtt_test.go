@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// MQTT 3.1.1 packet types http://docs.oasis-open.org/mqtt/mqtt/v3.1.1/os/mqtt-v3.1.1-os.html
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttPingreq    = 12
+	mqttDisconnect = 14
+)
+
+type mqttMessage struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+type mqttClientInfo struct {
+	clientID  string
+	user      string
+	willTopic string
+	will      string
+}
+
+// fakeBroker accept MQTT clients and record connections and published messages, only CONNECT, PUBLISH, PINGREQ and DISCONNECT are supported
+type fakeBroker struct {
+	listener     net.Listener
+	clients      chan mqttClientInfo
+	messages     chan mqttMessage
+	disconnected chan string
+}
+
+func startFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{listener: listener, clients: make(chan mqttClientInfo, 10), messages: make(chan mqttMessage, 100), disconnected: make(chan string, 10)}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func readMqttPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for {
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&127) * multiplier
+		multiplier *= 128
+		if digit&128 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+	return header, body, err
+}
+
+// readMqttString return UTF-8 string with length prefix and rest of data
+func readMqttString(data []byte) (string, []byte, error) {
+	if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+		return "", nil, errors.New("short MQTT string")
+	}
+	length := int(binary.BigEndian.Uint16(data))
+	return string(data[2 : 2+length]), data[2+length:], nil
+}
+
+func parseMqttConnect(body []byte) (mqttClientInfo, error) {
+	var info mqttClientInfo
+	_, rest, err := readMqttString(body)
+	if err != nil || len(rest) < 4 {
+		return info, errors.New("invalid CONNECT")
+	}
+	flags := rest[1]
+	if info.clientID, rest, err = readMqttString(rest[4:]); err != nil {
+		return info, err
+	}
+	if flags&0x04 != 0 {
+		if info.willTopic, rest, err = readMqttString(rest); err != nil {
+			return info, err
+		}
+		if info.will, rest, err = readMqttString(rest); err != nil {
+			return info, err
+		}
+	}
+	if flags&0x80 != 0 {
+		info.user, _, err = readMqttString(rest)
+	}
+	return info, err
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	var clientID string
+	for {
+		header, body, err := readMqttPacket(reader)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case mqttConnect:
+			info, err := parseMqttConnect(body)
+			if err != nil {
+				return
+			}
+			clientID = info.clientID
+			b.clients <- info
+			_, _ = conn.Write([]byte{mqttConnack << 4, 2, 0, 0})
+		case mqttPublish:
+			topic, rest, err := readMqttString(body)
+			if err != nil {
+				return
+			}
+			if qos := (header >> 1) & 3; qos > 0 {
+				_, _ = conn.Write([]byte{mqttPuback << 4, 2, rest[0], rest[1]})
+				rest = rest[2:]
+			}
+			b.messages <- mqttMessage{topic: topic, payload: string(rest), retain: header&1 == 1}
+		case mqttPingreq:
+			_, _ = conn.Write([]byte{0xd0, 0})
+		case mqttDisconnect:
+			b.disconnected <- clientID
+			return
+		}
+	}
+}
+
+// collect return messages published to broker until all expected topics are received
+func (b *fakeBroker) collect(t *testing.T, topics ...string) map[string]mqttMessage {
+	t.Helper()
+	result := map[string]mqttMessage{}
+	timeout := time.After(5 * time.Second)
+	for {
+		missing := false
+		for _, topic := range topics {
+			if _, ok := result[topic]; !ok {
+				missing = true
+			}
+		}
+		if !missing {
+			return result
+		}
+		select {
+		case message := <-b.messages:
+			result[message.topic] = message
+		case <-timeout:
+			t.Fatalf("expected topics %q get %v", topics, result)
+		}
+	}
+}
+
+func TestMqttPublisher(t *testing.T) {
+	b := startFakeBroker(t)
+	publisher, err := newMqttPublisher(mqttConfig{Broker: b.url(), User: "exporter", Topic: "home/ups/", QoS: 1, Retain: true}, "nut.local", "ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = publisher.connect(); err != nil {
+		t.Fatal(err)
+	}
+	info := <-b.clients
+	expected := mqttClientInfo{clientID: applicationName + "_nut.local_ups", user: "exporter", willTopic: "home/ups/nut.local/ups/availability", will: mqttOffline}
+	if info != expected {
+		t.Errorf("expected client %+v get %+v", expected, info)
+	}
+	prefix := "home/ups/nut.local/ups/"
+	if m := b.collect(t, prefix+"availability")[prefix+"availability"]; m.payload != mqttOnline || !m.retain {
+		t.Errorf("expected retained online availability get %+v", m)
+	}
+
+	now := time.Unix(1590000000, 0).UTC()
+	publisher.handleData("battery.charge: 100\nups.status: OB LB", now)
+	messages := b.collect(t, prefix+"battery.charge", prefix+"ups.status", prefix+"state")
+	if m := messages[prefix+"ups.status"]; m.payload != "OB LB" || !m.retain {
+		t.Errorf("unexpected status message %+v", m)
+	}
+	var state mqttState
+	if err = json.Unmarshal([]byte(messages[prefix+"state"].payload), &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Ups != "ups" || len(state.Flags) != 2 || state.Flags[1] != "LB" || state.Variables["battery.charge"] != "100" || !state.Time.Equal(now) {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	publisher.handleEvents([]upsEvent{{Time: now, Ups: "ups", Type: eventStatus, From: "OL", To: "OB LB"}})
+	if m := b.collect(t, prefix+"event")[prefix+"event"]; m.retain {
+		t.Errorf("event must not be retained %+v", m)
+	}
+
+	publisher.close()
+	if m := b.collect(t, prefix+"availability")[prefix+"availability"]; m.payload != mqttOffline || !m.retain {
+		t.Errorf("expected retained offline availability get %+v", m)
+	}
+	select {
+	case clientID := <-b.disconnected:
+		if clientID != info.clientID {
+			t.Errorf("unexpected disconnected client %q", clientID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher isn't disconnected")
+	}
+	publisher.close()
+
+	// closed publisher can connect again
+	if err = publisher.connect(); err != nil {
+		t.Fatal(err)
+	}
+	<-b.clients
+	publisher.close()
+}
+
+func TestMqttPublisherConflicts(t *testing.T) {
+	c := mqttConfig{Broker: "tcp://broker:1883"}
+	base, _ := newMqttPublisher(c, "nut", "ups")
+	tests := []struct {
+		name      string
+		config    mqttConfig
+		server    string
+		ups       string
+		conflicts bool
+		reusable  bool
+	}{
+		{name: "same", config: c, server: "nut", ups: "ups", conflicts: true, reusable: true},
+		{name: "other ups", config: c, server: "nut", ups: "ups2"},
+		{name: "other broker", config: mqttConfig{Broker: "tcp://other:1883"}, server: "nut", ups: "ups"},
+		{name: "same client ID", config: mqttConfig{Broker: c.Broker, ClientID: applicationName + "_nut_ups", Topic: "other"}, server: "nut", ups: "ups", conflicts: true},
+		{name: "same topic", config: mqttConfig{Broker: c.Broker, ClientID: "other", Retain: true}, server: "nut", ups: "ups", conflicts: true},
+	}
+	for _, test := range tests {
+		other, err := newMqttPublisher(test.config, test.server, test.ups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conflicts := base.conflicts(other); conflicts != test.conflicts {
+			t.Errorf("%s: expected conflicts %v", test.name, test.conflicts)
+		}
+		if reusable := base.reusable(test.config, test.server, test.ups); reusable != test.reusable {
+			t.Errorf("%s: expected reusable %v", test.name, test.reusable)
+		}
+	}
+}
+
+func TestMqttHomeAssistantDiscovery(t *testing.T) {
+	b := startFakeBroker(t)
+	publisher, err := newMqttPublisher(mqttConfig{Broker: b.url(), HomeAssistant: true, DiscoveryPrefix: "ha"}, "nut", "ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = publisher.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.close()
+	<-b.clients
+	b.collect(t, "nut/nut/ups/availability")
+
+	publisher.handleData("ups.mfr: APC\nups.model: Smart-UPS 1500\nups.serial: AS1234\nbattery.charge: 100\ndriver.version.internal: 0.44", time.Now())
+	topic := "ha/sensor/ups_driver_version_internal/config"
+	messages := b.collect(t, topic, "ha/sensor/ups_battery_charge/config")
+	var config haConfig
+	if err = json.Unmarshal([]byte(messages[topic].payload), &config); err != nil {
+		t.Fatal(err)
+	}
+	expected := haConfig{
+		Name:              "Driver version internal",
+		UniqueID:          applicationName + "_AS1234_driver_version_internal",
+		StateTopic:        "nut/nut/ups/driver.version.internal",
+		AvailabilityTopic: "nut/nut/ups/availability",
+		Device:            haDevice{Identifiers: []string{"AS1234"}, Name: "ups", Manufacturer: "APC", Model: "Smart-UPS 1500"},
+	}
+	if !messages[topic].retain || !reflect.DeepEqual(config, expected) {
+		t.Errorf("expected retained config %+v get %+v", expected, config)
+	}
+	if err = json.Unmarshal([]byte(messages["ha/sensor/ups_battery_charge/config"].payload), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.DeviceClass != "battery" || config.Unit != "%" || config.StateClass != "measurement" {
+		t.Errorf("known variable config hasn't hints %+v", config)
+	}
+
+	// config is published for variable that appear later only
+	publisher.handleData("ups.serial: AS1234\nbattery.charge: 99\nbattery.runtime: 1200", time.Now())
+	timeout := time.After(5 * time.Second)
+	for {
+		var message mqttMessage
+		select {
+		case message = <-b.messages:
+		case <-timeout:
+			t.Fatal("config of new variable isn't published")
+		}
+		if message.topic == "ha/sensor/ups_battery_runtime/config" {
+			break
+		}
+		if _, ok := messages[message.topic]; ok && strings.HasPrefix(message.topic, "ha/") {
+			t.Errorf("config of %s is published again", message.topic)
+		}
+		messages[message.topic] = message
+	}
+}
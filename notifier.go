@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"text/template"
+	"time"
+)
+
+type webhookConfig struct {
+	URL     string            `yaml:"url" json:"url"`
+	Body    string            `yaml:"body" json:"body"`
+	Headers map[string]string `yaml:"headers" json:"headers"`
+	Events  []string          `yaml:"events" json:"events"`
+	Flags   []string          `yaml:"flags" json:"flags"`
+	Retries int               `yaml:"retries" json:"retries"`
+	Timeout int               `yaml:"timeout" json:"timeout"`
+}
+
+type webhookData struct {
+	upsEvent
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+const (
+	webhookQueueSize = 100
+	// webhookDefaultBody send whole event as JSON
+	webhookDefaultBody = "{{ json . }}"
+)
+
+// webhookFuncs are template functions, json encode value as JSON (with quotes for strings) and urlquery escape value for URL query
+var webhookFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		content, err := json.Marshal(value)
+		return string(content), err
+	},
+	"urlquery": url.QueryEscape,
+}
+
+// webhook send notifications in order of events, every webhook has own queue so slow webhook doesn't delay others
+type webhook struct {
+	config webhookConfig
+	url    *template.Template
+	body   *template.Template
+	client *http.Client
+	queue  chan webhookData
+	done   chan struct{}
+}
+
+type webhookNotifier struct {
+	hooks []*webhook
+}
+
+func (w *webhookConfig) validate() []error {
+	var errs []error
+	if len(w.URL) < 1 {
+		errs = append(errs, errors.New("webhook URL must be defined"))
+	}
+	if _, err := template.New("url").Funcs(webhookFuncs).Parse(w.URL); err != nil {
+		errs = append(errs, fmt.Errorf("webhook URL template isn't valid: %s", err))
+	}
+	if _, err := template.New("body").Funcs(webhookFuncs).Parse(w.Body); err != nil {
+		errs = append(errs, fmt.Errorf("webhook body template isn't valid: %s", err))
+	}
+	for _, event := range w.Events {
+		if event != eventStatus && event != eventAlarm && event != eventTest {
+			errs = append(errs, errors.New("webhook event ["+event+"] isn't valid (status, alarm, test)"))
+		}
+	}
+	if w.Retries < 0 || w.Retries > 10 {
+		errs = append(errs, errors.New("webhook retries is out of range (0-10)"))
+	}
+	if w.Timeout < 0 || w.Timeout > 60 {
+		errs = append(errs, errors.New("webhook timeout is out of range (0-60 sec)"))
+	}
+	return errs
+}
+
+func newWebhookNotifier(configs []webhookConfig) *webhookNotifier {
+	notifier := &webhookNotifier{}
+	for _, c := range configs {
+		timeout := c.Timeout
+		if timeout == 0 {
+			timeout = 5
+		}
+		body := c.Body
+		if len(body) == 0 {
+			body = webhookDefaultBody
+		}
+		hook := &webhook{
+			config: c,
+			url:    template.Must(template.New("url").Funcs(webhookFuncs).Parse(c.URL)),
+			body:   template.Must(template.New("body").Funcs(webhookFuncs).Parse(body)),
+			client: &http.Client{Timeout: time.Duration(timeout) * time.Second},
+			queue:  make(chan webhookData, webhookQueueSize),
+			done:   make(chan struct{}),
+		}
+		go hook.run()
+		notifier.hooks = append(notifier.hooks, hook)
+	}
+	return notifier
+}
+
+func newWebhookData(event upsEvent) webhookData {
+	data := webhookData{upsEvent: event}
+	if event.Type != eventStatus {
+		return data
+	}
+	from := parseStatusFlags(event.From)
+	to := parseStatusFlags(event.To)
+	for flag := range to {
+		if !from[flag] {
+			data.Added = append(data.Added, flag)
+		}
+	}
+	for flag := range from {
+		if !to[flag] {
+			data.Removed = append(data.Removed, flag)
+		}
+	}
+	sort.Strings(data.Added)
+	sort.Strings(data.Removed)
+	return data
+}
+
+func (n *webhookNotifier) handleEvents(events []upsEvent) {
+	for _, event := range events {
+		data := newWebhookData(event)
+		for _, hook := range n.hooks {
+			if hook.match(data) {
+				hook.enqueue(data)
+			}
+		}
+	}
+}
+
+// close stop all webhooks, notifications waiting in queue are dropped
+func (n *webhookNotifier) close() {
+	for _, hook := range n.hooks {
+		select {
+		case <-hook.done:
+		default:
+			close(hook.done)
+		}
+	}
+}
+
+// enqueue add notification to queue, when queue is full the oldest notification is dropped
+func (h *webhook) enqueue(data webhookData) {
+	for {
+		select {
+		case <-h.done:
+			return
+		case h.queue <- data:
+			return
+		default:
+		}
+		select {
+		case dropped := <-h.queue:
+			_ = level.Warn(logger).Log("msg", "webhook queue is full, drop oldest notification", "url", h.config.URL, "time", dropped.Time)
+		default:
+		}
+	}
+}
+
+// run send notifications one by one in order of events until webhook is closed
+func (h *webhook) run() {
+	for {
+		select {
+		case <-h.done:
+			return
+		case data := <-h.queue:
+			h.send(data)
+		}
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// match apply event filter, empty filter match all events
+func (h *webhook) match(data webhookData) bool {
+	if len(h.config.Events) > 0 && !contains(h.config.Events, data.Type) {
+		return false
+	}
+	if len(h.config.Flags) == 0 || data.Type != eventStatus {
+		return true
+	}
+	for _, flag := range append(data.Added, data.Removed...) {
+		if contains(h.config.Flags, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *webhook) render(data webhookData) (string, []byte, error) {
+	var address, body bytes.Buffer
+	if err := h.url.Execute(&address, data); err != nil {
+		return "", nil, err
+	}
+	if err := h.body.Execute(&body, data); err != nil {
+		return "", nil, err
+	}
+	return address.String(), body.Bytes(), nil
+}
+
+// send POST event to webhook, failed request is repeated with exponential backoff
+func (h *webhook) send(data webhookData) {
+	url, body, err := h.render(data)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "problem render webhook template", "url", h.config.URL, "error", err)
+		return
+	}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err = h.post(url, body)
+		if err == nil {
+			_ = level.Debug(logger).Log("msg", "webhook notification sent", "url", url, "ups", data.Ups, "type", data.Type)
+			return
+		}
+		if attempt >= h.config.Retries {
+			break
+		}
+		_ = level.Warn(logger).Log("msg", "webhook notification failed, retry", "url", url, "attempt", attempt+1, "error", err)
+		select {
+		case <-h.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	_ = level.Error(logger).Log("msg", "webhook notification failed", "url", url, "ups", data.Ups, "type", data.Type, "error", err)
+}
+
+func (h *webhook) post(url string, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range h.config.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := h.client.Do(request)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("server returned status %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookServer struct {
+	*httptest.Server
+	requests chan *http.Request
+	bodies   chan string
+	failures int
+	mutex    sync.Mutex
+}
+
+// newWebhookServer start server which record requests, first failures requests return status 500
+func newWebhookServer(t *testing.T, failures int) *webhookServer {
+	s := &webhookServer{requests: make(chan *http.Request, 100), bodies: make(chan string, 100), failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mutex.Lock()
+		fail := s.failures > 0
+		s.failures--
+		s.mutex.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// slow response, next notification must wait for this one
+		time.Sleep(10 * time.Millisecond)
+		s.requests <- r
+		s.bodies <- string(body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) body(t *testing.T) string {
+	t.Helper()
+	select {
+	case body := <-s.bodies:
+		return body
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook notification isn't received")
+	}
+	return ""
+}
+
+func TestWebhookOrder(t *testing.T) {
+	s := newWebhookServer(t, 0)
+	notifier := newWebhookNotifier([]webhookConfig{{URL: s.URL + "/ups/{{.Ups}}", Headers: map[string]string{"X-Token": "secret"}}})
+	defer notifier.close()
+	now := time.Unix(1590000000, 0).UTC()
+	statuses := []string{"OL", "OB", "OB LB", "OB LB FSD", "OL CHRG"}
+	for i := 1; i < len(statuses); i++ {
+		notifier.handleEvents([]upsEvent{{Time: now, Ups: "ups", Type: eventStatus, From: statuses[i-1], To: statuses[i]}})
+	}
+	for i := 1; i < len(statuses); i++ {
+		var event upsEvent
+		if err := json.Unmarshal([]byte(s.body(t)), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.To != statuses[i] {
+			t.Fatalf("notification %d: expected status %q get %q", i, statuses[i], event.To)
+		}
+		request := <-s.requests
+		if request.URL.Path != "/ups/ups" || request.Header.Get("X-Token") != "secret" {
+			t.Errorf("unexpected request %s %v", request.URL, request.Header)
+		}
+	}
+}
+
+func TestWebhookTemplateFilterAndRetry(t *testing.T) {
+	s := newWebhookServer(t, 1)
+	notifier := newWebhookNotifier([]webhookConfig{{
+		URL:     s.URL,
+		Body:    `{"text": "{{.Ups}} {{range .Added}}+{{.}}{{end}}{{range .Removed}}-{{.}}{{end}}"}`,
+		Flags:   []string{"OB"},
+		Retries: 1,
+	}})
+	defer notifier.close()
+	notifier.handleEvents([]upsEvent{
+		{Ups: "ups", Type: eventStatus, From: "OL", To: "OL CHRG"},
+		{Ups: "ups", Type: eventStatus, From: "OL CHRG", To: "OB"},
+	})
+	if body := s.body(t); body != `{"text": "ups +OB-CHRG-OL"}` {
+		t.Errorf("unexpected body %s", body)
+	}
+	select {
+	case body := <-s.bodies:
+		t.Errorf("event without flag OB is sent: %s", body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// values with quotes are escaped by json and urlquery functions
+func TestWebhookTemplateFuncs(t *testing.T) {
+	s := newWebhookServer(t, 0)
+	defaultServer := newWebhookServer(t, 0)
+	notifier := newWebhookNotifier([]webhookConfig{
+		{URL: s.URL + "/notify?ups={{ urlquery .Ups }}&alarm={{ urlquery .To }}", Body: `{"text": {{ json .To }}, "added": {{ json .Added }}}`},
+		{URL: defaultServer.URL},
+	})
+	defer notifier.close()
+	now := time.Unix(1590000000, 0).UTC()
+	alarm := upsEvent{Time: now, Ups: "rack 1", Type: eventAlarm, From: "", To: `Replace "battery" & check \ fuse`}
+	notifier.handleEvents([]upsEvent{alarm})
+
+	body := s.body(t)
+	if query := (<-s.requests).URL.Query(); query.Get("ups") != alarm.Ups || query.Get("alarm") != alarm.To {
+		t.Errorf("unexpected query %v", query)
+	}
+	var text struct {
+		Text  string   `json:"text"`
+		Added []string `json:"added"`
+	}
+	if err := json.Unmarshal([]byte(body), &text); err != nil {
+		t.Fatalf("body isn't valid JSON: %s\n%s", err, body)
+	}
+	if text.Text != alarm.To || text.Added != nil {
+		t.Errorf("unexpected body %s", body)
+	}
+	body = defaultServer.body(t)
+	var event upsEvent
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		t.Fatalf("default body isn't valid JSON: %s\n%s", err, body)
+	}
+	if event != alarm {
+		t.Errorf("expected default body %+v get %s", alarm, body)
+	}
+
+	if errs := (&webhookConfig{URL: "http://hooks/{{ urlquery .Ups }}", Body: "{{ json .To }}"}).validate(); len(errs) != 0 {
+		t.Errorf("template with functions isn't valid: %v", errs)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"net"
 	"net/textproto"
+	"strconv"
 	"strings"
 )
 
@@ -19,20 +20,65 @@ type connection struct {
 	TCPConn    net.Conn
 }
 
+// newConnection create connection, with empty user connection is anonymous without USERNAME, PASSWORD and LOGIN
 func newConnection(host, user, pass, upsName string) *connection {
 	return &connection{host, user, pass, upsName, nil}
 }
 
+// dialNut is used for all connections to NUT server, record and replay replace it
+var dialNut = dialServer
+
+// dialServer connect to host:port, unix socket (unix:/path) or targets from SRV record (_nut._tcp.example.com)
+func dialServer(host string) (net.Conn, error) {
+	switch serverKind(host) {
+	case serverUnix:
+		return net.Dial("unix", unixSocketPath(host))
+	case serverSRV:
+		targets, err := lookupSRVTargets(host)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range targets {
+			var dialed net.Conn
+			if dialed, err = net.Dial("tcp", target); err == nil {
+				return dialed, nil
+			}
+		}
+		return nil, err
+	}
+	return net.Dial("tcp", host)
+}
+
+// lookupSRVTargets return host:port of all targets from SRV record in order of priority
+func lookupSRVTargets(name string) ([]string, error) {
+	_, records, err := net.LookupSRV("", "", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no target in SRV record " + name)
+	}
+	var targets []string
+	for _, record := range records {
+		targets = append(targets, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+	}
+	return targets, nil
+}
+
 func (conn *connection) open() error {
 	if conn.TCPConn != nil {
 		_ = conn.TCPConn.Close()
 	}
-	dialedConn, err := net.Dial("tcp", conn.Host)
+	dialedConn, err := dialNut(conn.Host)
 	if err != nil {
 		_ = level.Error(logger).Log("msg", "problem connect to NUT server ["+conn.Host+"]", "error", err, "host", conn.Host)
 		return err
 	}
 	conn.TCPConn = dialedConn
+	if len(conn.User) == 0 {
+		_ = level.Debug(logger).Log("msg", "anonymous connection to NUT server for ups name ["+conn.UPSName+"]", "ups", conn.UPSName)
+		return nil
+	}
 	_, err = conn.commandExpect("USERNAME "+conn.User, "OK")
 	if err != nil {
 		_ = level.Error(logger).Log("msg", err, "ups", conn.UPSName)
@@ -69,7 +115,8 @@ func (conn *connection) command(input string) (string, error) {
 	return strings.TrimSuffix(output, "\n"), nil
 }
 
-func (conn *connection) commandList(input string) ([]string, error) {
+// commandListRaw return lines of LIST response between BEGIN and END
+func (conn *connection) commandListRaw(input string) ([]string, error) {
 	_, _ = fmt.Fprintf(conn.TCPConn, "%s\r\n", input)
 	reader := bufio.NewReader(conn.TCPConn)
 	output := textproto.NewReader(reader)
@@ -79,16 +126,52 @@ func (conn *connection) commandList(input string) ([]string, error) {
 		if err != nil {
 			return data, err
 		}
-		s := strings.Split(line, " ")
-		show := s[2] + ": " + strings.TrimSuffix(strings.TrimPrefix(s[3], "\""), "\"")
-		data = append(data, show)
-		if line[:3] == "END" {
+		if strings.HasPrefix(line, "ERR ") {
+			return data, errors.New("server returned: " + line)
+		}
+		if strings.HasPrefix(line, "BEGIN ") {
+			continue
+		}
+		if strings.HasPrefix(line, "END ") {
 			break
 		}
+		data = append(data, line)
 	}
 	return data, nil
 }
 
+func (conn *connection) commandList(input string) ([]string, error) {
+	lines, err := conn.commandListRaw(input)
+	var data []string
+	for _, line := range lines {
+		s := strings.SplitN(line, " ", 4)
+		if len(s) < 4 {
+			return data, errors.New("unexpected line from server: " + line)
+		}
+		show := s[2] + ": " + unquote(s[3])
+		data = append(data, show)
+	}
+	return data, err
+}
+
+// unquote remove quotes around value and escape characters inside
+func unquote(value string) string {
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "\""), "\"")
+	return strings.NewReplacer("\\\"", "\"", "\\\\", "\\").Replace(value)
+}
+
+// parseVarList convert output of LIST VAR into map variable name -> value
+func parseVarList(output string) map[string]string {
+	vars := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		s := strings.SplitN(line, ": ", 2)
+		if len(s) == 2 {
+			vars[s[0]] = s[1]
+		}
+	}
+	return vars
+}
+
 func (conn *connection) commandExpect(input, expected string) (string, error) {
 	result, err := conn.command(input)
 	if err != nil {
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"github.com/pokornyIt/nut_exporter/nutsim"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSimulation = `
+users:
+  - name: monitor
+    password: secret
+ups:
+  - name: ups
+    variables:
+      ups.status: OL CHRG
+      ups.mfr: 'APC "Smart"'
+      ups.test.result: Done and passed
+      battery.charge: "100"
+`
+
+// startSimulation start fake NUT server, server is closed at end of test
+func startSimulation(t *testing.T) *nutsim.Server {
+	t.Helper()
+	c, err := nutsim.ParseConfig([]byte(testSimulation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := nutsim.NewServer(c)
+	if err = s.Start(""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestConnectionGetList(t *testing.T) {
+	s := startSimulation(t)
+	expected := []string{
+		"battery.charge: 100",
+		"ups.mfr: APC \"Smart\"",
+		"ups.status: OL CHRG",
+		"ups.test.result: Done and passed",
+	}
+	for _, user := range []string{"monitor", ""} {
+		conn := newConnection(s.Addr(), user, "secret", "ups")
+		output, err := readVarList(*conn)
+		if err != nil {
+			t.Fatalf("user %q: %s", user, err)
+		}
+		if lines := strings.Split(output, "\n"); !reflect.DeepEqual(lines, expected) {
+			t.Errorf("user %q: expected %q get %q", user, expected, lines)
+		}
+	}
+}
+
+func TestConnectionErrors(t *testing.T) {
+	s := startSimulation(t)
+	if _, err := readVarList(*newConnection(s.Addr(), "monitor", "wrong", "ups")); err == nil || !strings.Contains(err.Error(), "ACCESS-DENIED") {
+		t.Errorf("expected ACCESS-DENIED get %v", err)
+	}
+	if _, err := readVarList(*newConnection(s.Addr(), "", "", "unknown")); err == nil || !strings.Contains(err.Error(), "UNKNOWN-UPS") {
+		t.Errorf("expected UNKNOWN-UPS get %v", err)
+	}
+	if err := s.SetError("ups", "DATA-STALE"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readVarList(*newConnection(s.Addr(), "", "", "ups")); err == nil || !strings.Contains(err.Error(), "DATA-STALE") {
+		t.Errorf("expected DATA-STALE get %v", err)
+	}
+}
+
+func TestStatusRegex(t *testing.T) {
+	output := strings.Join([]string{
+		"ups.status.extra: X",
+		"xups.status: Y",
+		"ups.status: OB LB",
+		"ups.alarm: Replace battery!",
+		"ups.test.result: Done and passed",
+	}, "\n")
+	tests := []struct {
+		name     string
+		regex    func() (string, bool)
+		expected string
+	}{
+		{"status", func() (string, bool) { return readValue(upsStatusRegex, output) }, "OB LB"},
+		{"alarm", func() (string, bool) { return readValue(upsAlarmRegex, output) }, "Replace battery!"},
+		{"test result", func() (string, bool) { return readValue(upsTestResultRegex, output) }, "Done and passed"},
+	}
+	for _, test := range tests {
+		if value, ok := test.regex(); !ok || value != test.expected {
+			t.Errorf("%s: expected %q get %q", test.name, test.expected, value)
+		}
+	}
+	if value, ok := readValue(upsStatusRegex, "ups.status.extra: X\n"); ok {
+		t.Errorf("variable with longer name match status: %q", value)
+	}
+}
+
+// LIST protocol parsing: well formed responses come from simulation, malformed ones from scripted connection
+
+func TestCommandListSimulation(t *testing.T) {
+	s := startSimulation(t)
+	for name, value := range map[string]string{"ups.status": "OB DISCHRG LB", "ups.mfr": `APC "Smart" \ UPS`, "ups.id": ""} {
+		if err := s.Set("ups", name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn := newConnection(s.Addr(), "", "", "ups")
+	if err := conn.open(); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.close()
+	data, err := conn.commandList("LIST VAR ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"battery.charge: 100",
+		"ups.id: ",
+		`ups.mfr: APC "Smart" \ UPS`,
+		"ups.status: OB DISCHRG LB",
+		"ups.test.result: Done and passed",
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("expected %q get %q", expected, data)
+	}
+	if err = s.SetError("ups", "DRIVER-NOT-CONNECTED"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.commandList("LIST VAR ups"); err == nil || err.Error() != "server returned: ERR DRIVER-NOT-CONNECTED" {
+		t.Errorf("expected DRIVER-NOT-CONNECTED get %v", err)
+	}
+}
+
+// scriptedConnection return connection to server which read one command and reply with response
+func scriptedConnection(t *testing.T, response string) *connection {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+	go func() {
+		defer server.Close()
+		if _, err := bufio.NewReader(server).ReadString('\n'); err == nil {
+			_, _ = server.Write([]byte(response))
+		}
+	}()
+	return &connection{UPSName: "ups", TCPConn: client}
+}
+
+func TestCommandListMalformed(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		raw      []string
+		err      string
+	}{
+		{
+			name:     "empty list",
+			response: "BEGIN LIST CMD ups\nEND LIST CMD ups\n",
+		},
+		{
+			name:     "missing end",
+			response: "BEGIN LIST VAR ups\nVAR ups ups.status \"OL\"\n",
+			raw:      []string{`VAR ups ups.status "OL"`},
+			err:      "EOF",
+		},
+		{
+			name:     "short line",
+			response: "BEGIN LIST VAR ups\nVAR ups\nEND LIST VAR ups\n",
+			raw:      []string{"VAR ups"},
+			err:      "unexpected line from server: VAR ups",
+		},
+	}
+	for _, test := range tests {
+		raw, err := scriptedConnection(t, test.response).commandListRaw("LIST VAR ups")
+		if !reflect.DeepEqual(raw, test.raw) {
+			t.Errorf("%s: expected lines %q get %q", test.name, test.raw, raw)
+		}
+		_, err = scriptedConnection(t, test.response).commandList("LIST VAR ups")
+		if (err == nil) != (len(test.err) == 0) || (err != nil && err.Error() != test.err) {
+			t.Errorf("%s: expected error %q get %v", test.name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package nutsim implements fake NUT server (upsd) text protocol for tests and demos.
+//
+// Server serve UPS definitions from YAML, variables can change over time by script,
+// script step can also inject protocol errors, slow responses or connection drops.
+package nutsim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Config is definition of simulated server
+type Config struct {
+	Listen string `yaml:"listen"`
+	Users  []User `yaml:"users"`
+	Ups    []Ups  `yaml:"ups"`
+}
+
+// User allowed to login, without users any USERNAME and PASSWORD are accepted
+type User struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+}
+
+// Ups is one simulated UPS
+type Ups struct {
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description"`
+	Variables   map[string]string `yaml:"variables"`
+	Commands    map[string]string `yaml:"commands"`
+	Delay       time.Duration     `yaml:"delay"`
+	Loop        time.Duration     `yaml:"loop"`
+	Script      []Step            `yaml:"script"`
+}
+
+// Step change UPS at time from server start, variables stay changed,
+// error, delay and drop are active only until next step
+type Step struct {
+	At        time.Duration     `yaml:"at"`
+	Variables map[string]string `yaml:"variables"`
+	Remove    []string          `yaml:"remove"`
+	Error     string            `yaml:"error"`
+	Delay     time.Duration     `yaml:"delay"`
+	Drop      bool              `yaml:"drop"`
+}
+
+// LoadConfig read configuration from YAML file
+func LoadConfig(filename string) (*Config, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(content)
+}
+
+// ParseConfig parse and validate YAML configuration
+func ParseConfig(content []byte) (*Config, error) {
+	c := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return c, c.Validate()
+}
+
+// Validate check that every UPS has unique name and script steps are in order
+func (c *Config) Validate() error {
+	if len(c.Ups) == 0 {
+		return errors.New("at least one UPS must be defined")
+	}
+	names := map[string]bool{}
+	for _, ups := range c.Ups {
+		if len(ups.Name) == 0 {
+			return errors.New("UPS name must be defined")
+		}
+		if names[ups.Name] {
+			return fmt.Errorf("UPS [%s] is defined more times", ups.Name)
+		}
+		names[ups.Name] = true
+		for i, step := range ups.Script {
+			if i > 0 && step.At < ups.Script[i-1].At {
+				return fmt.Errorf("UPS [%s] script step %d is before previous step", ups.Name, i)
+			}
+			if ups.Loop > 0 && step.At >= ups.Loop {
+				return fmt.Errorf("UPS [%s] script step %d is after loop time", ups.Name, i)
+			}
+		}
+	}
+	return nil
+}
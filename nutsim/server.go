@@ -0,0 +1,514 @@
+package nutsim
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// protocol details https://networkupstools.org/docs/developer-guide.chunked/ar01s09.html
+
+const (
+	version         = "Network UPS Tools upsd 2.8.0 - simulated by nutsim"
+	protocolVersion = "1.3"
+	defaultListen   = "127.0.0.1:0"
+)
+
+// Server is fake NUT server
+type Server struct {
+	config   *Config
+	listener net.Listener
+	started  time.Time
+	ups      map[string]*upsState
+	conns    map[net.Conn]bool
+	mutex    sync.Mutex
+	wg       sync.WaitGroup
+}
+
+type upsState struct {
+	config    Ups
+	overrides map[string]string
+	err       string
+	clients   map[string]int
+}
+
+// upsView is UPS state in one moment
+type upsView struct {
+	variables map[string]string
+	err       string
+	delay     time.Duration
+	drop      bool
+}
+
+type session struct {
+	server   *Server
+	conn     net.Conn
+	writer   *bufio.Writer
+	user     string
+	password string
+	login    string
+}
+
+// NewServer create server for configuration, server must be started by Start
+func NewServer(c *Config) *Server {
+	s := &Server{config: c, ups: map[string]*upsState{}, conns: map[net.Conn]bool{}}
+	for _, ups := range c.Ups {
+		s.ups[ups.Name] = &upsState{config: ups, overrides: map[string]string{}, clients: map[string]int{}}
+	}
+	return s
+}
+
+// Start listen on address (empty use address from configuration or random local port) and serve clients in background,
+// script time start now
+func (s *Server) Start(address string) error {
+	if len(address) == 0 {
+		address = s.config.Listen
+	}
+	if len(address) == 0 {
+		address = defaultListen
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.listener = listener
+	s.started = time.Now()
+	s.mutex.Unlock()
+	s.wg.Add(1)
+	go s.accept()
+	return nil
+}
+
+// Addr return address where server listen, empty when server isn't started
+func (s *Server) Addr() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Close stop listening and close all client connections, server which isn't started has nothing to close
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	listener := s.listener
+	s.mutex.Unlock()
+	if listener == nil {
+		return nil
+	}
+	err := listener.Close()
+	s.mutex.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mutex.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Set change variable of UPS, change has priority over script
+func (s *Server) Set(ups, name, value string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, ok := s.ups[ups]
+	if !ok {
+		return errors.New("unknown UPS " + ups)
+	}
+	state.overrides[name] = value
+	return nil
+}
+
+// SetError set error code (e.g. DATA-STALE) returned for all commands about UPS, empty code clear error
+func (s *Server) SetError(ups, code string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, ok := s.ups[ups]
+	if !ok {
+		return errors.New("unknown UPS " + ups)
+	}
+	state.err = code
+	return nil
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.conns[conn] = true
+		s.mutex.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	ses := &session{server: s, conn: conn, writer: bufio.NewWriter(conn)}
+	defer func() {
+		s.mutex.Lock()
+		if state, ok := s.ups[ses.login]; ok {
+			state.clients[ses.clientAddress()]--
+			if state.clients[ses.clientAddress()] <= 0 {
+				delete(state.clients, ses.clientAddress())
+			}
+		}
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		_ = conn.Close()
+		s.wg.Done()
+	}()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		var keep bool
+		if args, err := splitArgs(strings.TrimRight(line, "\r\n")); err != nil {
+			keep = ses.sendError("INVALID-ARGUMENT")
+		} else {
+			keep = ses.handle(args)
+		}
+		// reply must be flushed also when connection is closed after command (LOGOUT)
+		if ses.writer.Flush() != nil || !keep {
+			return
+		}
+	}
+}
+
+// view compute UPS state in actual time from script and overrides
+func (s *Server) view(name string) (upsView, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, ok := s.ups[name]
+	if !ok {
+		return upsView{}, false
+	}
+	view := upsView{variables: map[string]string{}, err: state.err, delay: state.config.Delay}
+	for key, value := range state.config.Variables {
+		view.variables[key] = value
+	}
+	elapsed := time.Since(s.started)
+	if state.config.Loop > 0 {
+		elapsed %= state.config.Loop
+	}
+	for _, step := range state.config.Script {
+		if step.At > elapsed {
+			break
+		}
+		for key, value := range step.Variables {
+			view.variables[key] = value
+		}
+		for _, key := range step.Remove {
+			delete(view.variables, key)
+		}
+		view.delay = state.config.Delay + step.Delay
+		view.drop = step.Drop
+		if len(state.err) == 0 {
+			view.err = step.Error
+		}
+	}
+	for key, value := range state.overrides {
+		view.variables[key] = value
+	}
+	return view, true
+}
+
+func (ses *session) clientAddress() string {
+	host, _, err := net.SplitHostPort(ses.conn.RemoteAddr().String())
+	if err != nil {
+		return ses.conn.RemoteAddr().String()
+	}
+	return host
+}
+
+func (ses *session) send(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(ses.writer, format+"\n", args...)
+}
+
+func (ses *session) sendError(code string) bool {
+	ses.send("ERR %s", code)
+	return true
+}
+
+// handle process one command, false close connection
+func (ses *session) handle(args []string) bool {
+	if len(args) == 0 {
+		return ses.sendError("UNKNOWN-COMMAND")
+	}
+	switch strings.ToUpper(args[0]) {
+	case "VER":
+		ses.send(version)
+	case "NETVER":
+		ses.send(protocolVersion)
+	case "HELP":
+		ses.send("Commands: HELP VER GET LIST SET INSTCMD LOGIN LOGOUT USERNAME PASSWORD STARTTLS")
+	case "STARTTLS":
+		return ses.sendError("FEATURE-NOT-CONFIGURED")
+	case "USERNAME":
+		return ses.credential(args, &ses.user, "ALREADY-SET-USERNAME")
+	case "PASSWORD":
+		return ses.credential(args, &ses.password, "ALREADY-SET-PASSWORD")
+	case "LOGIN":
+		return ses.loginUps(args)
+	case "LOGOUT":
+		ses.send("OK Goodbye")
+		return false
+	case "LIST":
+		return ses.list(args)
+	case "GET":
+		return ses.get(args)
+	case "INSTCMD":
+		return ses.instantCommand(args)
+	default:
+		return ses.sendError("UNKNOWN-COMMAND")
+	}
+	return true
+}
+
+func (ses *session) credential(args []string, value *string, already string) bool {
+	if len(args) != 2 {
+		return ses.sendError("INVALID-ARGUMENT")
+	}
+	if len(*value) > 0 {
+		return ses.sendError(already)
+	}
+	*value = args[1]
+	ses.send("OK")
+	return true
+}
+
+func (ses *session) authorized() bool {
+	if len(ses.server.config.Users) == 0 {
+		return true
+	}
+	for _, user := range ses.server.config.Users {
+		if user.Name == ses.user && user.Password == ses.password {
+			return true
+		}
+	}
+	return false
+}
+
+func (ses *session) loginUps(args []string) bool {
+	switch {
+	case len(args) != 2:
+		return ses.sendError("INVALID-ARGUMENT")
+	case len(ses.login) > 0:
+		return ses.sendError("ALREADY-LOGGED-IN")
+	case len(ses.user) == 0:
+		return ses.sendError("USERNAME-REQUIRED")
+	case len(ses.password) == 0:
+		return ses.sendError("PASSWORD-REQUIRED")
+	case !ses.authorized():
+		return ses.sendError("ACCESS-DENIED")
+	}
+	view, ok := ses.prepare(args[1])
+	if !ok {
+		return !view.drop
+	}
+	ses.server.mutex.Lock()
+	ses.server.ups[args[1]].clients[ses.clientAddress()]++
+	ses.server.mutex.Unlock()
+	ses.login = args[1]
+	ses.send("OK")
+	return true
+}
+
+// prepare read UPS state, apply delay and send error when UPS is unknown or error is injected
+func (ses *session) prepare(name string) (upsView, bool) {
+	view, ok := ses.server.view(name)
+	if !ok {
+		ses.sendError("UNKNOWN-UPS")
+		return view, false
+	}
+	time.Sleep(view.delay)
+	if view.drop {
+		return view, false
+	}
+	if len(view.err) > 0 {
+		ses.sendError(view.err)
+		return view, false
+	}
+	return view, true
+}
+
+// splitArgs split command line to words like upsd, word in double quotes can contain spaces
+// and backslash escape next character
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord, quoted, escaped := false, false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == '"':
+			quoted, inWord = !quoted, true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+func quote(value string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(value) + "\""
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (ses *session) list(args []string) bool {
+	if len(args) < 2 {
+		return ses.sendError("INVALID-ARGUMENT")
+	}
+	kind := strings.ToUpper(args[1])
+	if kind == "UPS" {
+		ses.send("BEGIN LIST UPS")
+		for _, ups := range ses.server.config.Ups {
+			ses.send("UPS %s %s", ups.Name, quote(ups.Description))
+		}
+		ses.send("END LIST UPS")
+		return true
+	}
+	if len(args) != 3 {
+		return ses.sendError("INVALID-ARGUMENT")
+	}
+	name := args[2]
+	view, ok := ses.prepare(name)
+	if !ok {
+		return !view.drop
+	}
+	var lines []string
+	switch kind {
+	case "VAR":
+		for _, key := range sortedKeys(view.variables) {
+			lines = append(lines, fmt.Sprintf("VAR %s %s %s", name, key, quote(view.variables[key])))
+		}
+	case "CMD":
+		for _, key := range sortedKeys(ses.server.ups[name].config.Commands) {
+			lines = append(lines, fmt.Sprintf("CMD %s %s", name, key))
+		}
+	case "CLIENT":
+		ses.server.mutex.Lock()
+		for client := range ses.server.ups[name].clients {
+			lines = append(lines, fmt.Sprintf("CLIENT %s %s", name, client))
+		}
+		ses.server.mutex.Unlock()
+		sort.Strings(lines)
+	case "RW":
+	default:
+		return ses.sendError("INVALID-ARGUMENT")
+	}
+	ses.send("BEGIN LIST %s %s", kind, name)
+	for _, line := range lines {
+		ses.send(line)
+	}
+	ses.send("END LIST %s %s", kind, name)
+	return true
+}
+
+func (ses *session) get(args []string) bool {
+	if len(args) < 3 {
+		return ses.sendError("INVALID-ARGUMENT")
+	}
+	kind := strings.ToUpper(args[1])
+	name := args[2]
+	view, ok := ses.prepare(name)
+	if !ok {
+		return !view.drop
+	}
+	state := ses.server.ups[name]
+	switch {
+	case kind == "UPSDESC" && len(args) == 3:
+		ses.send("UPSDESC %s %s", name, quote(state.config.Description))
+	case kind == "NUMLOGINS" && len(args) == 3:
+		ses.server.mutex.Lock()
+		count := 0
+		for _, logins := range state.clients {
+			count += logins
+		}
+		ses.server.mutex.Unlock()
+		ses.send("NUMLOGINS %s %d", name, count)
+	case kind == "VAR" && len(args) == 4:
+		value, ok := view.variables[args[3]]
+		if !ok {
+			return ses.sendError("VAR-NOT-SUPPORTED")
+		}
+		ses.send("VAR %s %s %s", name, args[3], quote(value))
+	case kind == "TYPE" && len(args) == 4:
+		if _, ok := view.variables[args[3]]; !ok {
+			return ses.sendError("VAR-NOT-SUPPORTED")
+		}
+		ses.send("TYPE %s %s STRING:64", name, args[3])
+	case kind == "DESC" && len(args) == 4:
+		ses.send("DESC %s %s %s", name, args[3], quote("Description unavailable"))
+	case kind == "CMDDESC" && len(args) == 4:
+		description, ok := state.config.Commands[args[3]]
+		if !ok {
+			return ses.sendError("CMD-NOT-SUPPORTED")
+		}
+		if len(description) == 0 {
+			description = "Description unavailable"
+		}
+		ses.send("CMDDESC %s %s %s", name, args[3], quote(description))
+	default:
+		return ses.sendError("INVALID-ARGUMENT")
+	}
+	return true
+}
+
+func (ses *session) instantCommand(args []string) bool {
+	switch {
+	case len(args) < 3:
+		return ses.sendError("INVALID-ARGUMENT")
+	case len(ses.user) == 0:
+		return ses.sendError("USERNAME-REQUIRED")
+	case len(ses.password) == 0:
+		return ses.sendError("PASSWORD-REQUIRED")
+	case !ses.authorized():
+		return ses.sendError("ACCESS-DENIED")
+	}
+	view, ok := ses.prepare(args[1])
+	if !ok {
+		return !view.drop
+	}
+	if _, ok := ses.server.ups[args[1]].config.Commands[args[2]]; !ok {
+		return ses.sendError("CMD-NOT-SUPPORTED")
+	}
+	ses.send("OK")
+	return true
+}
@@ -0,0 +1,166 @@
+package nutsim
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+users:
+  - name: monitor
+    password: "pa ss\"word"
+ups:
+  - name: ups
+    description: Test UPS
+    variables:
+      ups.status: OL CHRG
+      battery.charge: "100"
+`
+
+func startServer(t *testing.T) *Server {
+	t.Helper()
+	c, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(c)
+	if err = s.Start(""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+type client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, s *Server) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &client{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// command send line and return expected number of response lines
+func (c *client) command(t *testing.T, line string, lines int) []string {
+	t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "%s\n", line); err != nil {
+		t.Fatal(err)
+	}
+	var response []string
+	for i := 0; i < lines; i++ {
+		read, err := c.reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("command %s: %s", line, err)
+		}
+		response = append(response, strings.TrimRight(read, "\n"))
+	}
+	return response
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		args []string
+		err  bool
+	}{
+		{line: "LIST VAR ups", args: []string{"LIST", "VAR", "ups"}},
+		{line: "  GET   VAR\tups ups.status ", args: []string{"GET", "VAR", "ups", "ups.status"}},
+		{line: `PASSWORD "pa ss\"word"`, args: []string{"PASSWORD", `pa ss"word`}},
+		{line: `USERNAME a\ b`, args: []string{"USERNAME", "a b"}},
+		{line: `SET VAR ups ups.id ""`, args: []string{"SET", "VAR", "ups", "ups.id", ""}},
+		{line: "", args: nil},
+		{line: `PASSWORD "secret`, err: true},
+		{line: `PASSWORD secret\`, err: true},
+	}
+	for _, test := range tests {
+		args, err := splitArgs(test.line)
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%q: expected %q get %q", test.line, test.args, args)
+		}
+	}
+}
+
+func TestServerLogin(t *testing.T) {
+	s := startServer(t)
+	c := dial(t, s)
+	steps := []struct {
+		command  string
+		response string
+	}{
+		{"LOGIN ups", "ERR USERNAME-REQUIRED"},
+		{"USERNAME monitor", "OK"},
+		{"PASSWORD pa ss", "ERR INVALID-ARGUMENT"},
+		{`PASSWORD "pa ss\"word"`, "OK"},
+		{"LOGIN other", "ERR UNKNOWN-UPS"},
+		{"LOGIN ups", "OK"},
+		{"GET VAR ups ups.status", `VAR ups ups.status "OL CHRG"`},
+		{`GET VAR ups "battery.charge"`, `VAR ups battery.charge "100"`},
+		{`GET VAR "ups`, "ERR INVALID-ARGUMENT"},
+	}
+	for _, step := range steps {
+		if response := c.command(t, step.command, 1)[0]; response != step.response {
+			t.Errorf("%s: expected %q get %q", step.command, step.response, response)
+		}
+	}
+}
+
+// LOGOUT reply is flushed before server close connection
+func TestServerLogout(t *testing.T) {
+	s := startServer(t)
+	c := dial(t, s)
+	if response := c.command(t, "LOGOUT", 1)[0]; response != "OK Goodbye" {
+		t.Fatalf("expected OK Goodbye get %q", response)
+	}
+	if _, err := c.reader.ReadString('\n'); err == nil {
+		t.Fatal("connection isn't closed after LOGOUT")
+	}
+}
+
+func TestServerListVar(t *testing.T) {
+	s := startServer(t)
+	if err := s.Set("ups", "ups.status", "OB LB"); err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, s)
+	expected := []string{
+		"BEGIN LIST VAR ups",
+		`VAR ups battery.charge "100"`,
+		`VAR ups ups.status "OB LB"`,
+		"END LIST VAR ups",
+	}
+	if response := c.command(t, "LIST VAR ups", len(expected)); !reflect.DeepEqual(response, expected) {
+		t.Errorf("expected %q get %q", expected, response)
+	}
+	if err := s.SetError("ups", "DATA-STALE"); err != nil {
+		t.Fatal(err)
+	}
+	if response := c.command(t, "LIST VAR ups", 1)[0]; response != "ERR DATA-STALE" {
+		t.Errorf("expected ERR DATA-STALE get %q", response)
+	}
+}
+
+func TestServerNotStarted(t *testing.T) {
+	s := NewServer(&Config{Ups: []Ups{{Name: "ups"}}})
+	if addr := s.Addr(); addr != "" {
+		t.Errorf("expected empty address get %q", addr)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
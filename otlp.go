@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLP/HTTP with JSON encoding https://opentelemetry.io/docs/specs/otlp/#otlphttp
+
+const otlpCumulative = 2
+
+type otlpConfig struct {
+	Endpoint string            `yaml:"endpoint" json:"endpoint"`
+	Headers  map[string]string `yaml:"headers" json:"headers"`
+	Timeout  int               `yaml:"timeout" json:"timeout"`
+	Retries  int               `yaml:"retries" json:"retries"`
+}
+
+type otlpExporter struct {
+	config   otlpConfig
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	start    time.Time
+	ups      string
+	busy     chan struct{}
+	done     chan struct{}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          *float64        `json:"asDouble,omitempty"`
+	Count             string          `json:"count,omitempty"`
+	Sum               *float64        `json:"sum,omitempty"`
+	BucketCounts      []string        `json:"bucketCounts,omitempty"`
+	ExplicitBounds    []float64       `json:"explicitBounds,omitempty"`
+}
+
+type otlpData struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality,omitempty"`
+	IsMonotonic            bool            `json:"isMonotonic,omitempty"`
+}
+
+type otlpMetric struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Unit        string    `json:"unit,omitempty"`
+	Gauge       *otlpData `json:"gauge,omitempty"`
+	Sum         *otlpData `json:"sum,omitempty"`
+	Histogram   *otlpData `json:"histogram,omitempty"`
+}
+
+type otlpScopeMetrics struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	} `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+func (o *otlpConfig) enabled() bool {
+	return len(o.Endpoint) > 0
+}
+
+func (o *otlpConfig) validate() []error {
+	if !o.enabled() {
+		return nil
+	}
+	var errs []error
+	u, err := url.Parse(o.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		errs = append(errs, errors.New("OTLP endpoint must be valid http:// or https:// URL"))
+	}
+	if o.Timeout < 0 || o.Timeout > 60 {
+		errs = append(errs, errors.New("OTLP timeout is out of range (0-60 sec)"))
+	}
+	if o.Retries < 0 || o.Retries > 10 {
+		errs = append(errs, errors.New("OTLP retries is out of range (0-10)"))
+	}
+	return errs
+}
+
+func newOtlpExporter(c otlpConfig, gatherer prometheus.Gatherer, ups string) *otlpExporter {
+	if c.Timeout == 0 {
+		c.Timeout = 10
+	}
+	return &otlpExporter{
+		config:   c,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: time.Duration(c.Timeout) * time.Second},
+		start:    time.Now(),
+		ups:      ups,
+		busy:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+func otlpAttributes(values map[string]string) []otlpAttribute {
+	var keys []string
+	for key, value := range values {
+		if len(value) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var attributes []otlpAttribute
+	for _, key := range keys {
+		attributes = append(attributes, otlpAttribute{key, otlpValue{values[key]}})
+	}
+	return attributes
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func otlpUnit(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_seconds"), strings.HasPrefix(name, nameSpace+"_ups_delay_"):
+		return "s"
+	case strings.HasSuffix(name, "_voltage"), strings.HasSuffix(name, "_voltage_nominal"):
+		return "V"
+	case strings.HasSuffix(name, "_charge"), strings.HasPrefix(name, nameSpace+"_battery_charge_"), name == nameSpace+"_ups_load":
+		return "%"
+	case name == nameSpace+"_ups_temp":
+		return "Cel"
+	case name == nameSpace+"_ups_power_nominal":
+		return "VA"
+	case name == nameSpace+"_ups_real_power_nominal":
+		return "W"
+	}
+	return ""
+}
+
+// metrics convert gathered families to OTLP metrics and add status flags as separate gauge
+func (e *otlpExporter) metrics(families []*dto.MetricFamily, vars map[string]string, now time.Time) []otlpMetric {
+	var metrics []otlpMetric
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), nameSpace+"_") {
+			continue
+		}
+		metric := otlpMetric{Name: family.GetName(), Description: family.GetHelp(), Unit: otlpUnit(family.GetName())}
+		data := &otlpData{}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, pair := range m.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			point := otlpDataPoint{Attributes: otlpAttributes(labels), TimeUnixNano: otlpTime(now)}
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				value := m.GetGauge().GetValue()
+				if !isFinite(value) {
+					continue
+				}
+				point.AsDouble = &value
+			case dto.MetricType_COUNTER:
+				value := m.GetCounter().GetValue()
+				if !isFinite(value) {
+					continue
+				}
+				point.AsDouble = &value
+				point.StartTimeUnixNano = otlpTime(e.start)
+			case dto.MetricType_HISTOGRAM:
+				histogram := m.GetHistogram()
+				sum := histogram.GetSampleSum()
+				if !isFinite(sum) {
+					continue
+				}
+				point.Sum = &sum
+				point.Count = strconv.FormatUint(histogram.GetSampleCount(), 10)
+				point.StartTimeUnixNano = otlpTime(e.start)
+				previous := uint64(0)
+				for _, bucket := range histogram.GetBucket() {
+					point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+					point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(bucket.GetCumulativeCount()-previous, 10))
+					previous = bucket.GetCumulativeCount()
+				}
+				point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(histogram.GetSampleCount()-previous, 10))
+			default:
+				continue
+			}
+			data.DataPoints = append(data.DataPoints, point)
+		}
+		if len(data.DataPoints) == 0 {
+			// OTLP JSON can't encode NaN or Inf, metric with only such points is skipped
+			continue
+		}
+		switch family.GetType() {
+		case dto.MetricType_GAUGE:
+			metric.Gauge = data
+		case dto.MetricType_COUNTER:
+			data.AggregationTemporality = otlpCumulative
+			data.IsMonotonic = true
+			metric.Sum = data
+		case dto.MetricType_HISTOGRAM:
+			data.AggregationTemporality = otlpCumulative
+			metric.Histogram = data
+		default:
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	flags := &otlpData{}
+	for _, flag := range strings.Fields(vars["ups.status"]) {
+		value := 1.0
+		flags.DataPoints = append(flags.DataPoints, otlpDataPoint{
+			Attributes:   otlpAttributes(map[string]string{"flag": flag}),
+			TimeUnixNano: otlpTime(now),
+			AsDouble:     &value,
+		})
+	}
+	if len(flags.DataPoints) > 0 {
+		metrics = append(metrics, otlpMetric{Name: nameSpace + "_ups_status_flag", Description: "Actual UPS status flags (OL, OB, LB, ...)", Gauge: flags})
+	}
+	return metrics
+}
+
+// handleData export metrics in background, poll is skipped while previous export or its retry is running,
+// data after close are ignored
+func (e *otlpExporter) handleData(output string, now time.Time) {
+	select {
+	case <-e.done:
+		return
+	default:
+	}
+	select {
+	case e.busy <- struct{}{}:
+	default:
+		_ = level.Warn(logger).Log("msg", "previous OTLP export still running, skip this poll")
+		return
+	}
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		_ = level.Warn(logger).Log("msg", "problem gather metrics for OTLP", "error", err)
+	}
+	vars := parseVarList(output)
+	resource := otlpResourceMetrics{}
+	resource.Resource.Attributes = otlpAttributes(map[string]string{
+		"service.name":        applicationName,
+		"service.version":     Version,
+		"ups.name":            e.ups,
+		"device.model":        vars["ups.model"],
+		"device.manufacturer": vars["ups.mfr"],
+	})
+	scope := otlpScopeMetrics{Metrics: e.metrics(families, vars, now)}
+	scope.Scope.Name = applicationName
+	scope.Scope.Version = Version
+	resource.ScopeMetrics = []otlpScopeMetrics{scope}
+	go func() {
+		defer func() { <-e.busy }()
+		e.export(otlpRequest{[]otlpResourceMetrics{resource}})
+	}()
+}
+
+// export send request, failed request is repeated with exponential backoff until exporter is closed
+func (e *otlpExporter) export(data otlpRequest) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := e.send(data)
+		if err == nil {
+			_ = level.Debug(logger).Log("msg", "metrics exported with OTLP", "endpoint", e.config.Endpoint)
+			return
+		}
+		if attempt >= e.config.Retries {
+			_ = level.Error(logger).Log("msg", "problem export metrics with OTLP", "endpoint", e.config.Endpoint, "error", err)
+			return
+		}
+		_ = level.Warn(logger).Log("msg", "OTLP export failed, retry", "endpoint", e.config.Endpoint, "attempt", attempt+1, "error", err)
+		select {
+		case <-e.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (e *otlpExporter) send(data otlpRequest) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, e.config.Endpoint, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range e.config.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := e.client.Do(request)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("server returned status %s", response.Status)
+	}
+	return nil
+}
+
+func (e *otlpExporter) close() {
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOtlpMetricsSkipNonFinite(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: nameSpace + "_test_value", Help: "test"}, []string{"kind"})
+	nan := prometheus.NewGauge(prometheus.GaugeOpts{Name: nameSpace + "_test_nan", Help: "test"})
+	registry.MustRegister(gauge, nan)
+	gauge.WithLabelValues("finite").Set(1)
+	gauge.WithLabelValues("inf").Set(math.Inf(1))
+	nan.Set(math.NaN())
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newOtlpExporter(otlpConfig{Endpoint: "http://localhost"}, registry, "ups")
+	metrics := e.metrics(families, map[string]string{}, time.Now())
+	if len(metrics) != 1 {
+		t.Fatalf("expected only metric with finite value, get %d metrics", len(metrics))
+	}
+	if metrics[0].Name != nameSpace+"_test_value" || len(metrics[0].Gauge.DataPoints) != 1 {
+		t.Fatalf("unexpected metric %+v", metrics[0])
+	}
+	if _, err = json.Marshal(metrics); err != nil {
+		t.Fatalf("metrics can't be encoded to JSON: %s", err)
+	}
+}
+
+// otlpServer record exported requests, first failures requests return status 503
+type otlpServer struct {
+	*httptest.Server
+	requests chan otlpRequest
+	mutex    sync.Mutex
+	failures int
+	count    int
+}
+
+func newOtlpServer(t *testing.T, failures int) *otlpServer {
+	s := &otlpServer{requests: make(chan otlpRequest, 10), failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Token") != "secret" {
+			t.Errorf("unexpected request %s %v", r.Method, r.Header)
+		}
+		s.mutex.Lock()
+		s.count++
+		fail := s.count <= s.failures
+		s.mutex.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var request otlpRequest
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Errorf("request isn't valid JSON: %s", err)
+		}
+		s.requests <- request
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *otlpServer) requestCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.count
+}
+
+func (s *otlpServer) request(t *testing.T) otlpRequest {
+	t.Helper()
+	select {
+	case request := <-s.requests:
+		return request
+	case <-time.After(5 * time.Second):
+		t.Fatal("OTLP request isn't received")
+	}
+	return otlpRequest{}
+}
+
+func TestOtlpExporterSend(t *testing.T) {
+	s := newOtlpServer(t, 0)
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: nameSpace + "_battery_charge", Help: "test"})
+	registry.MustRegister(gauge)
+	gauge.Set(95)
+	e := newOtlpExporter(otlpConfig{Endpoint: s.URL, Headers: map[string]string{"X-Token": "secret"}}, registry, "rack")
+	defer e.close()
+	now := time.Unix(1590000000, 0).UTC()
+	e.handleData("ups.mfr: APC\nups.model: Smart-UPS 1500\nups.status: OB LB", now)
+
+	request := s.request(t)
+	if len(request.ResourceMetrics) != 1 || len(request.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("unexpected request %+v", request)
+	}
+	resource := request.ResourceMetrics[0]
+	attributes := map[string]string{}
+	for _, attribute := range resource.Resource.Attributes {
+		attributes[attribute.Key] = attribute.Value.StringValue
+	}
+	expected := map[string]string{
+		"service.name":        applicationName,
+		"service.version":     Version,
+		"ups.name":            "rack",
+		"device.model":        "Smart-UPS 1500",
+		"device.manufacturer": "APC",
+	}
+	if len(Version) == 0 {
+		delete(expected, "service.version")
+	}
+	if !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("expected resource attributes %v get %v", expected, attributes)
+	}
+	metrics := map[string]otlpMetric{}
+	for _, metric := range resource.ScopeMetrics[0].Metrics {
+		metrics[metric.Name] = metric
+	}
+	if charge := metrics[nameSpace+"_battery_charge"]; charge.Unit != "%" || charge.Gauge == nil || *charge.Gauge.DataPoints[0].AsDouble != 95 {
+		t.Errorf("unexpected battery charge %+v", charge)
+	}
+	flags := metrics[nameSpace+"_ups_status_flag"]
+	if flags.Gauge == nil || len(flags.Gauge.DataPoints) != 2 {
+		t.Fatalf("unexpected status flag metric %+v", flags)
+	}
+	for i, flag := range []string{"OB", "LB"} {
+		point := flags.Gauge.DataPoints[i]
+		if !reflect.DeepEqual(point.Attributes, []otlpAttribute{{"flag", otlpValue{flag}}}) || *point.AsDouble != 1 || point.TimeUnixNano != otlpTime(now) {
+			t.Errorf("unexpected status flag point %+v", point)
+		}
+	}
+}
+
+func TestOtlpExporterRetry(t *testing.T) {
+	s := newOtlpServer(t, 1)
+	e := newOtlpExporter(otlpConfig{Endpoint: s.URL, Headers: map[string]string{"X-Token": "secret"}, Retries: 1}, prometheus.NewRegistry(), "ups")
+	e.handleData("ups.status: OL", time.Now())
+	// poll during retry is skipped
+	e.handleData("ups.status: OB", time.Now())
+	request := s.request(t)
+	if flags := request.ResourceMetrics[0].ScopeMetrics[0].Metrics[0]; flags.Gauge.DataPoints[0].Attributes[0].Value.StringValue != "OL" {
+		t.Errorf("unexpected retried request %+v", flags)
+	}
+	if count := s.requestCount(); count != 2 {
+		t.Errorf("expected 2 requests get %d", count)
+	}
+	waitFor(t, func() bool { return len(e.busy) == 0 })
+
+	// data after close are ignored
+	e.close()
+	e.handleData("ups.status: OL", time.Now())
+	e.close()
+	time.Sleep(50 * time.Millisecond)
+	if count := s.requestCount(); count != 2 {
+		t.Errorf("request is sent after close, %d requests", count)
+	}
+}
+
+// close stop retry of failed export
+func TestOtlpExporterCloseStopRetry(t *testing.T) {
+	s := newOtlpServer(t, 10)
+	e := newOtlpExporter(otlpConfig{Endpoint: s.URL, Headers: map[string]string{"X-Token": "secret"}, Retries: 10}, prometheus.NewRegistry(), "ups")
+	e.handleData("ups.status: OL", time.Now())
+	waitFor(t, func() bool { return s.requestCount() == 1 })
+	e.close()
+	waitFor(t, func() bool { return len(e.busy) == 0 })
+	if count := s.requestCount(); count != 1 {
+		t.Errorf("export is retried after close, %d requests", count)
+	}
+}
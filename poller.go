@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type poller struct {
+	config       *configData
+	connection   connection
+	tracker      *statusTracker
+	handlers     []eventHandler
+	dataHandlers []dataHandler
+	mappings     []metricMapping
+	filter       *metricFilter
+	events       *eventLog
+	influx       *influxWriter
+	hooks        *hookRunner
+	mqtt         *mqttPublisher
+	reused       []closeHandler
+	mqttConflict bool
+	started      bool
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+var (
+	activePoller *poller
+	pollerMutex  sync.RWMutex
+)
+
+func currentPoller() *poller {
+	pollerMutex.RLock()
+	defer pollerMutex.RUnlock()
+	return activePoller
+}
+
+// currentConfig return configuration of running poller
+func currentConfig() *configData {
+	if p := currentPoller(); p != nil {
+		return p.config
+	}
+	return config
+}
+
+func registerMetrics() {
+	registerDataMetrics()
+	prometheus.MustRegister(upsStatusTransitions)
+	prometheus.MustRegister(upsStatusLastChange)
+	prometheus.MustRegister(upsOnBatteryDuration)
+	prometheus.MustRegister(configReloadSuccess)
+	prometheus.MustRegister(configReloadSuccessTime)
+	prometheus.MustRegister(configReloadFailures)
+}
+
+// newPoller create connection and all outputs for configuration, previous poller (can be nil) is poller
+// of running configuration, its status tracker is kept and its outputs are reused when configuration is same
+func newPoller(c *configData, previous *poller) (*poller, error) {
+	_ = level.Debug(logger).Log("msg", "create connection for NUT server", "host", c.getServer())
+	p := &poller{
+		config:     c,
+		connection: *newConnection(c.getServer(), c.loginUser(), c.Password, c.UpsName),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	var err error
+	if p.mappings, err = loadMetricMappings(c.MetricsMapping); err != nil {
+		return nil, err
+	}
+	if p.filter, err = c.Filters.filter(c.UpsName); err != nil {
+		return nil, err
+	}
+	if previous != nil && previous.tracker.upsName == c.UpsName {
+		p.tracker = previous.tracker
+	} else {
+		p.tracker = newStatusTracker(c.UpsName)
+	}
+	if len(c.EventLog) > 0 {
+		p.events = newEventLog(c.EventLog, int64(c.EventLogSize)*1024*1024, time.Duration(c.EventLogAge)*24*time.Hour)
+		if !p.tracker.started {
+			p.tracker.restore(p.events.lastEvents(c.UpsName))
+		}
+		p.handlers = append(p.handlers, p.events)
+		_ = level.Info(logger).Log("msg", "UPS events are stored in log", "file", c.EventLog)
+	}
+	if len(c.Webhooks) > 0 {
+		p.handlers = append(p.handlers, newWebhookNotifier(c.Webhooks))
+		_ = level.Info(logger).Log("msg", "UPS events are sent to webhooks", "count", len(c.Webhooks))
+	}
+	if len(c.Hooks) > 0 {
+		p.hooks = newHookRunner(c.Hooks, c.UpsName)
+		p.handlers = append(p.handlers, p.hooks)
+		p.dataHandlers = append(p.dataHandlers, p.hooks)
+		_ = level.Info(logger).Log("msg", "UPS status flags start hook commands", "count", len(c.Hooks))
+	}
+	if c.Mqtt.enabled() {
+		if p.mqtt, err = p.mqttPublisher(c, previous); err != nil {
+			p.abort(previous)
+			return nil, err
+		}
+		p.handlers = append(p.handlers, p.mqtt)
+		p.dataHandlers = append(p.dataHandlers, p.mqtt)
+	}
+	if c.Influx.enabled() {
+		p.influx = newInfluxWriter(c.Influx, c.serverName(), c.UpsName)
+		p.dataHandlers = append(p.dataHandlers, p.influx)
+	}
+	if c.RemoteWrite.enabled() {
+		p.dataHandlers = append(p.dataHandlers, newRemoteWriter(c.RemoteWrite, prometheus.DefaultGatherer))
+		_ = level.Info(logger).Log("msg", "metrics are pushed with remote write", "url", c.RemoteWrite.URL)
+	}
+	if c.Otlp.enabled() {
+		p.dataHandlers = append(p.dataHandlers, newOtlpExporter(c.Otlp, prometheus.DefaultGatherer, c.UpsName))
+		_ = level.Info(logger).Log("msg", "metrics are exported with OTLP", "endpoint", c.Otlp.Endpoint)
+	}
+	return p, nil
+}
+
+// mqttPublisher reuse publisher of previous poller with same configuration, otherwise connect new publisher,
+// previous publisher which conflicts with new one is disconnected first
+func (p *poller) mqttPublisher(c *configData, previous *poller) (*mqttPublisher, error) {
+	if previous != nil && previous.mqtt != nil && previous.mqtt.reusable(c.Mqtt, c.serverName(), c.UpsName) {
+		p.reused = append(p.reused, previous.mqtt)
+		return previous.mqtt, nil
+	}
+	publisher, err := newMqttPublisher(c.Mqtt, c.serverName(), c.UpsName)
+	if err != nil {
+		return nil, err
+	}
+	p.mqttConflict = previous != nil && previous.mqtt != nil && previous.mqtt.conflicts(publisher)
+	if p.mqttConflict {
+		previous.mqtt.close()
+	}
+	if err = publisher.connect(); err != nil {
+		return nil, err
+	}
+	return publisher, nil
+}
+
+// abort close outputs of poller which doesn't replace previous poller,
+// MQTT publisher of previous poller disconnected because of conflict is connected again
+func (p *poller) abort(previous *poller) {
+	p.closeOutputs(p.reused)
+	if p.mqttConflict {
+		if err := previous.mqtt.connect(); err != nil {
+			_ = level.Error(logger).Log("msg", "problem connect MQTT publisher of running configuration", "error", err)
+		}
+	}
+}
+
+func (p *poller) start() {
+	state.register(p.config.UpsName, p.config.getServer())
+	p.started = true
+	go p.run()
+}
+
+func (p *poller) run() {
+	defer close(p.done)
+	for {
+		p.poll()
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(time.Duration(p.config.Refresh) * time.Second):
+		}
+	}
+}
+
+// poll use wall clock without monotonic reading, so replay of recorded session compute same durations
+func (p *poller) poll() {
+	p.pollAt(time.Now().Round(0))
+}
+
+// pollAt read data from NUT server and update metrics and outputs, now is time of poll
+func (p *poller) pollAt(now time.Time) {
+	if activeRecorder != nil {
+		activeRecorder.session(now)
+	}
+	upsOutput, err := readVarList(p.connection)
+	if err == nil && len(upsOutput) == 0 {
+		err = errors.New("NUT server returned no variables")
+	}
+	state.update(p.config.UpsName, p.config.getServer(), upsOutput, err, now)
+
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "problem read data from NUT server", "error", err)
+		return
+	}
+	nutMetrics.update(p.mappings, p.filter, upsOutput)
+	// status metrics are updated before data handlers, so remote write and OTLP push them in same poll
+	upsEvents := p.tracker.update(upsOutput, now)
+	for _, handler := range p.dataHandlers {
+		handler.handleData(upsOutput, now)
+	}
+	for _, handler := range p.handlers {
+		handler.handleEvents(upsEvents)
+	}
+}
+
+// close stop polling (when started) and all outputs
+func (p *poller) close() {
+	p.stopPolling()
+	p.closeOutputs(nil)
+}
+
+// stopPolling stop polling and wait until running poll finish
+func (p *poller) stopPolling() {
+	select {
+	case <-p.stop:
+		return
+	default:
+		close(p.stop)
+	}
+	if p.started {
+		select {
+		case <-p.done:
+		case <-time.After(time.Minute):
+			_ = level.Warn(logger).Log("msg", "poller did not stop in time")
+		}
+	}
+}
+
+// closeOutputs close all outputs except outputs used by next poller
+func (p *poller) closeOutputs(keep []closeHandler) {
+	closed := map[closeHandler]bool{}
+	for _, handler := range keep {
+		closed[handler] = true
+	}
+	for _, handler := range p.handlers {
+		if c, ok := handler.(closeHandler); ok && !closed[c] {
+			c.close()
+			closed[c] = true
+		}
+	}
+	for _, handler := range p.dataHandlers {
+		if c, ok := handler.(closeHandler); ok && !closed[c] {
+			c.close()
+			closed[c] = true
+		}
+	}
+}
+
+// eventsHandler handle /api/v1/events of running poller
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	p := currentPoller()
+	if p == nil || p.events == nil {
+		http.NotFound(w, r)
+		return
+	}
+	p.events.ServeHTTP(w, r)
+}
+
+// influxHandler handle /influx of running poller
+func influxHandler(w http.ResponseWriter, r *http.Request) {
+	p := currentPoller()
+	if p == nil || p.influx == nil || !p.config.Influx.Pull {
+		http.NotFound(w, r)
+		return
+	}
+	p.influx.ServeHTTP(w, r)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"os"
+	"strings"
+)
+
+var (
+	queryCommand  = kingpin.Command("query", "Read data from NUT server like upsc, without NUT tools installed.")
+	queryJSON     = queryCommand.Flag("json", "Print output as JSON").Default("false").Bool()
+	queryListUps  = queryCommand.Command("list-ups", "List UPS names on server (upsc -l).")
+	queryVars     = queryCommand.Command("vars", "List all variables of UPS (upsc <ups>).")
+	queryVarsUps  = queryVars.Arg("ups", "UPS name").Required().String()
+	queryGet      = queryCommand.Command("get", "Print value of one variable (upsc <ups> <var>).")
+	queryGetUps   = queryGet.Arg("ups", "UPS name").Required().String()
+	queryGetVar   = queryGet.Arg("var", "Variable name").Required().String()
+	queryCmds     = queryCommand.Command("cmds", "List instant commands of UPS (upscmd -l <ups>).")
+	queryCmdsUps  = queryCmds.Arg("ups", "UPS name").Required().String()
+	queryClients  = queryCommand.Command("clients", "List clients connected to UPS (upsc -c <ups>).")
+	queryClientUp = queryClients.Arg("ups", "UPS name").Required().String()
+)
+
+type queryItem struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// queryFields split LIST or GET response line into count fields and unquote last field
+func queryFields(line string, count int) ([]string, error) {
+	s := strings.SplitN(line, " ", count)
+	if len(s) < count {
+		return nil, errors.New("unexpected line from server: " + line)
+	}
+	s[count-1] = unquote(s[count-1])
+	return s, nil
+}
+
+// queryListItems read LIST response, name is field on index, next field is description when exists
+func queryListItems(conn *connection, command string, count, index int) ([]queryItem, error) {
+	lines, err := conn.commandListRaw(command)
+	if err != nil {
+		return nil, err
+	}
+	items := []queryItem{}
+	for _, line := range lines {
+		s, err := queryFields(line, count)
+		if err != nil {
+			return nil, err
+		}
+		item := queryItem{Name: s[index]}
+		if len(s) > index+1 {
+			item.Description = s[index+1]
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func queryGetValue(conn *connection, command string, count int) (string, error) {
+	line, err := conn.command(command)
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r")
+	if strings.HasPrefix(line, "ERR ") {
+		return "", errors.New("server returned: " + line)
+	}
+	s, err := queryFields(line, count)
+	if err != nil {
+		return "", err
+	}
+	return s[count-1], nil
+}
+
+// runQuery execute query subcommand, output is compatible with upsc or upscmd
+func runQuery(c *configData, command string) error {
+	conn := newConnection(c.getServer(), "", "", "")
+	if err := conn.open(); err != nil {
+		return err
+	}
+	defer conn.close()
+	switch command {
+	case queryListUps.FullCommand():
+		items, err := queryListItems(conn, "LIST UPS", 3, 1)
+		if err != nil {
+			return err
+		}
+		if *queryJSON {
+			return queryPrintJSON(items)
+		}
+		for _, item := range items {
+			fmt.Println(item.Name)
+		}
+	case queryVars.FullCommand():
+		items, err := queryListItems(conn, "LIST VAR "+*queryVarsUps, 4, 2)
+		if err != nil {
+			return err
+		}
+		if *queryJSON {
+			vars := map[string]string{}
+			for _, item := range items {
+				vars[item.Name] = item.Description
+			}
+			return queryPrintJSON(vars)
+		}
+		for _, item := range items {
+			fmt.Printf("%s: %s\n", item.Name, item.Description)
+		}
+	case queryGet.FullCommand():
+		value, err := queryGetValue(conn, "GET VAR "+*queryGetUps+" "+*queryGetVar, 4)
+		if err != nil {
+			return err
+		}
+		if *queryJSON {
+			return queryPrintJSON(map[string]string{"ups": *queryGetUps, "name": *queryGetVar, "value": value})
+		}
+		fmt.Println(value)
+	case queryCmds.FullCommand():
+		items, err := queryListItems(conn, "LIST CMD "+*queryCmdsUps, 3, 2)
+		if err != nil {
+			return err
+		}
+		for i := range items {
+			items[i].Description, err = queryGetValue(conn, "GET CMDDESC "+*queryCmdsUps+" "+items[i].Name, 4)
+			if err != nil {
+				return err
+			}
+		}
+		if *queryJSON {
+			return queryPrintJSON(items)
+		}
+		fmt.Printf("Instant commands supported on UPS [%s]:\n\n", *queryCmdsUps)
+		for _, item := range items {
+			fmt.Printf("%s - %s\n", item.Name, item.Description)
+		}
+	case queryClients.FullCommand():
+		items, err := queryListItems(conn, "LIST CLIENT "+*queryClientUp, 3, 2)
+		if err != nil {
+			return err
+		}
+		clients := []string{}
+		for _, item := range items {
+			clients = append(clients, item.Name)
+		}
+		if *queryJSON {
+			return queryPrintJSON(clients)
+		}
+		for _, client := range clients {
+			fmt.Println(client)
+		}
+	}
+	return nil
+}
+
+func queryPrintJSON(data interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"github.com/pokornyIt/nut_exporter/nutsim"
+	"net"
+	"strings"
+	"testing"
+)
+
+const testQuerySimulation = `
+ups:
+  - name: ups
+    description: Rack UPS
+    variables:
+      ups.status: OL CHRG
+      ups.mfr: 'APC "Smart"'
+      battery.charge: "100"
+    commands:
+      beeper.off: Disable the UPS beeper
+      test.battery.start: ""
+  - name: backup
+    variables:
+      ups.status: OB
+`
+
+func TestRunQuery(t *testing.T) {
+	simulation, err := nutsim.ParseConfig([]byte(testQuerySimulation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := nutsim.NewServer(simulation)
+	if err = s.Start(""); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	client := newConnection(s.Addr(), "monitor", "secret", "ups")
+	if err = client.open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.close()
+	c := simulationConfig(t, s.Addr(), "", "")
+
+	flags := []*string{queryVarsUps, queryGetUps, queryGetVar, queryCmdsUps, queryClientUp}
+	values := make([]string, len(flags))
+	for i, flag := range flags {
+		values[i] = *flag
+	}
+	json := *queryJSON
+	defer func() {
+		for i, flag := range flags {
+			*flag = values[i]
+		}
+		*queryJSON = json
+	}()
+	*queryVarsUps, *queryCmdsUps, *queryClientUp = "ups", "ups", "ups"
+	*queryGetUps, *queryGetVar = "ups", "ups.mfr"
+	address, _, err := net.SplitHostPort(client.TCPConn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command  string
+		json     bool
+		expected string
+	}{
+		{command: queryListUps.FullCommand(), expected: "ups\nbackup\n"},
+		{command: queryListUps.FullCommand(), json: true, expected: `[
+  {
+    "name": "ups",
+    "description": "Rack UPS"
+  },
+  {
+    "name": "backup"
+  }
+]
+`},
+		{command: queryVars.FullCommand(), expected: "battery.charge: 100\nups.mfr: APC \"Smart\"\nups.status: OL CHRG\n"},
+		{command: queryVars.FullCommand(), json: true, expected: `{
+  "battery.charge": "100",
+  "ups.mfr": "APC \"Smart\"",
+  "ups.status": "OL CHRG"
+}
+`},
+		{command: queryGet.FullCommand(), expected: "APC \"Smart\"\n"},
+		{command: queryGet.FullCommand(), json: true, expected: `{
+  "name": "ups.mfr",
+  "ups": "ups",
+  "value": "APC \"Smart\""
+}
+`},
+		{command: queryCmds.FullCommand(), expected: "Instant commands supported on UPS [ups]:\n\n" +
+			"beeper.off - Disable the UPS beeper\ntest.battery.start - Description unavailable\n"},
+		{command: queryCmds.FullCommand(), json: true, expected: `[
+  {
+    "name": "beeper.off",
+    "description": "Disable the UPS beeper"
+  },
+  {
+    "name": "test.battery.start",
+    "description": "Description unavailable"
+  }
+]
+`},
+		{command: queryClients.FullCommand(), expected: address + "\n"},
+		{command: queryClients.FullCommand(), json: true, expected: "[\n  \"" + address + "\"\n]\n"},
+	}
+	for _, test := range tests {
+		*queryJSON = test.json
+		output := captureStdout(t, func() { err = runQuery(c, test.command) })
+		if err != nil {
+			t.Errorf("%s json %v: unexpected error %s", test.command, test.json, err)
+		}
+		if output != test.expected {
+			t.Errorf("%s json %v: expected output\n%s\nget\n%s", test.command, test.json, test.expected, output)
+		}
+	}
+
+	failures := []struct {
+		command string
+		ups     string
+		name    string
+		err     string
+	}{
+		{command: queryVars.FullCommand(), ups: "unknown", err: "UNKNOWN-UPS"},
+		{command: queryGet.FullCommand(), ups: "unknown", name: "ups.mfr", err: "UNKNOWN-UPS"},
+		{command: queryGet.FullCommand(), ups: "ups", name: "ups.unknown", err: "VAR-NOT-SUPPORTED"},
+		{command: queryCmds.FullCommand(), ups: "unknown", err: "UNKNOWN-UPS"},
+		{command: queryClients.FullCommand(), ups: "unknown", err: "UNKNOWN-UPS"},
+	}
+	for _, test := range failures {
+		*queryVarsUps, *queryCmdsUps, *queryClientUp, *queryGetUps, *queryGetVar = test.ups, test.ups, test.ups, test.ups, test.name
+		for _, json := range []bool{false, true} {
+			*queryJSON = json
+			output := captureStdout(t, func() { err = runQuery(c, test.command) })
+			if err == nil || !strings.Contains(err.Error(), "ERR "+test.err) {
+				t.Errorf("%s %s %s json %v: expected error %s get %v", test.command, test.ups, test.name, json, test.err, err)
+			}
+			if len(output) > 0 {
+				t.Errorf("%s %s %s json %v: unexpected output %q", test.command, test.ups, test.name, json, output)
+			}
+		}
+	}
+}
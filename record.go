@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// record file is text, every connection start with session line, commands and responses are prefixed
+//  # session 2020-06-01T10:00:00Z
+//  > LIST VAR ups
+//  < BEGIN LIST VAR ups
+//  ! dial error
+
+const (
+	recordSession  = "# session "
+	recordCommand  = "> "
+	recordResponse = "< "
+	recordError    = "! "
+	recordRedacted = "****"
+)
+
+var (
+	recordFile    = kingpin.Flag("record", "Save NUT protocol exchanges to file for replay, user and password are redacted").PlaceHolder("session.rec").Default("").String()
+	replayCommand = kingpin.Command("replay", "Replay NUT sessions saved by --record and print metrics.")
+	replayFile    = replayCommand.Arg("file", "File with recorded sessions").Required().ExistingFile()
+
+	activeRecorder *recorder
+)
+
+type recorder struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+type recordingConn struct {
+	net.Conn
+	recorder *recorder
+	written  string
+	read     string
+}
+
+type replayExchange struct {
+	command   string
+	responses []string
+}
+
+type replaySession struct {
+	time      time.Time
+	err       string
+	exchanges []replayExchange
+}
+
+func newRecorder(filename string) (*recorder, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{file: file}, nil
+}
+
+func (r *recorder) write(prefix, line string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, _ = fmt.Fprintf(r.file, "%s%s\n", prefix, line)
+}
+
+// lines write all complete lines from data and return rest of data
+func (r *recorder) lines(prefix, data string, convert func(string) string) string {
+	for {
+		i := strings.Index(data, "\n")
+		if i < 0 {
+			return data
+		}
+		r.write(prefix, convert(strings.TrimSuffix(data[:i], "\r")))
+		data = data[i+1:]
+	}
+}
+
+// session start new session, replay use time of session as time of poll
+func (r *recorder) session(now time.Time) {
+	r.write(recordSession, now.UTC().Format(time.RFC3339Nano))
+}
+
+// dial connect to NUT server and record all data on connection
+func (r *recorder) dial(host string) (net.Conn, error) {
+	conn, err := dialServer(host)
+	if err != nil {
+		r.write(recordError, err.Error())
+		return nil, err
+	}
+	return &recordingConn{Conn: conn, recorder: r}, nil
+}
+
+// redactCommand hide user and password
+func redactCommand(line string) string {
+	s := strings.SplitN(line, " ", 2)
+	if len(s) == 2 && (s[0] == "USERNAME" || s[0] == "PASSWORD") {
+		return s[0] + " " + recordRedacted
+	}
+	return line
+}
+
+func keepLine(line string) string {
+	return line
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.written = c.recorder.lines(recordCommand, c.written+string(b), redactCommand)
+	return c.Conn.Write(b)
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read = c.recorder.lines(recordResponse, c.read+string(b[:n]), keepLine)
+	return n, err
+}
+
+// loadRecord read sessions from record file
+func loadRecord(filename string) ([]replaySession, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var sessions []replaySession
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Text()
+		if strings.HasPrefix(line, recordSession) {
+			t, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, recordSession))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", number, err)
+			}
+			sessions = append(sessions, replaySession{time: t})
+			continue
+		}
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(sessions) == 0 {
+			return nil, fmt.Errorf("line %d: data before first session", number)
+		}
+		session := &sessions[len(sessions)-1]
+		switch {
+		case strings.HasPrefix(line, recordError):
+			session.err = strings.TrimPrefix(line, recordError)
+		case strings.HasPrefix(line, recordCommand):
+			session.exchanges = append(session.exchanges, replayExchange{command: strings.TrimPrefix(line, recordCommand)})
+		case strings.HasPrefix(line, recordResponse) && len(session.exchanges) > 0:
+			exchange := &session.exchanges[len(session.exchanges)-1]
+			exchange.responses = append(exchange.responses, strings.TrimPrefix(line, recordResponse))
+		default:
+			return nil, fmt.Errorf("line %d: unexpected data %q", number, line)
+		}
+	}
+	return sessions, scanner.Err()
+}
+
+// upsName return name of UPS from LOGIN or LIST VAR command
+func (s replaySession) upsName() string {
+	for _, exchange := range s.exchanges {
+		for _, prefix := range []string{"LOGIN ", "LIST VAR "} {
+			if strings.HasPrefix(exchange.command, prefix) {
+				return strings.TrimPrefix(exchange.command, prefix)
+			}
+		}
+	}
+	return ""
+}
+
+func (s replaySession) anonymous() bool {
+	for _, exchange := range s.exchanges {
+		if strings.HasPrefix(exchange.command, "USERNAME ") {
+			return false
+		}
+	}
+	return true
+}
+
+// dial return connection which answer recorded responses for commands in recorded order
+func (s replaySession) dial(string) (net.Conn, error) {
+	if len(s.err) > 0 {
+		return nil, errors.New(s.err)
+	}
+	client, server := net.Pipe()
+	go s.serve(server)
+	return client, nil
+}
+
+func (s replaySession) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for _, exchange := range s.exchanges {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if command := redactCommand(strings.TrimRight(line, "\r\n")); command != exchange.command {
+			_, _ = fmt.Fprintf(conn, "ERR REPLAY expected [%s] but get [%s]\n", exchange.command, command)
+			return
+		}
+		if len(exchange.responses) > 0 {
+			if _, err = fmt.Fprintf(conn, "%s\n", strings.Join(exchange.responses, "\n")); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// replay poll recorded sessions in order and print NUT metrics in Prometheus text format,
+// metrics mapping and filters are taken from configuration like in running exporter
+func replay() int {
+	sessions, err := loadRecord(*replayFile)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "problem read record file", "file", *replayFile, "error", err)
+		return 1
+	}
+	base := newConfig()
+	if fileExists(*configFile) {
+		err = base.load(*configFile, true)
+	} else {
+		err = base.applyOverrides()
+	}
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "problem read configuration", "file", *configFile, "error", err)
+		return 1
+	}
+	registerMetrics()
+	if err = replaySessions(sessions, base); err != nil {
+		_ = level.Error(logger).Log("msg", "problem create poller", "error", err)
+		return 1
+	}
+	if err = writeNutMetrics(os.Stdout); err != nil {
+		_ = level.Error(logger).Log("msg", "problem gather metrics", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// replaySessions poll every session with metrics mapping and filters from base configuration
+func replaySessions(sessions []replaySession, base *configData) error {
+	defer func() { dialNut = dialServer }()
+	var previous *poller
+	for _, session := range sessions {
+		c := newConfig()
+		c.Server = "replay"
+		c.UpsName = session.upsName()
+		c.MetricsMapping = base.MetricsMapping
+		c.Filters = base.Filters
+		if session.anonymous() {
+			c.Anonymous = true
+		} else {
+			c.User, c.Password = recordRedacted, recordRedacted
+		}
+		p, err := newPoller(c, previous)
+		if err != nil {
+			return err
+		}
+		dialNut = session.dial
+		p.pollAt(session.time)
+		previous = p
+	}
+	return nil
+}
+
+// writeNutMetrics write NUT metrics in Prometheus text format,
+// configuration reload metrics describe running exporter, not NUT data
+func writeNutMetrics(w io.Writer) error {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return err
+	}
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, family := range families {
+		if strings.HasPrefix(family.GetName(), nameSpace+"_") && !strings.HasPrefix(family.GetName(), nameSpace+"_config_") {
+			if err = encoder.Encode(family); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// metrics are registered in default registry only once for all runs of tests
+var registerTestMetrics sync.Once
+
+// replay of recorded sessions produce same metrics as live polling
+func TestRecordReplay(t *testing.T) {
+	s := startSimulation(t)
+	fileName := filepath.Join(filepath.Dir(writeTestFile(t, "empty", "")), "session.rec")
+	rec, err := newRecorder(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.file.Close()
+	activeRecorder, dialNut = rec, rec.dial
+	defer func() { activeRecorder, dialNut = nil, dialServer }()
+
+	base := newConfig()
+	base.Filters = filterConfig{Metrics: nameFilter{Exclude: []string{"nut_ups_mfr"}}}
+	host, port, err := net.SplitHostPort(s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newConfig()
+	c.Server, c.User, c.Password, c.Filters = host, "monitor", "secret", base.Filters
+	if _, err = fmt.Sscan(port, &c.Port); err != nil {
+		t.Fatal(err)
+	}
+	p, err := newPoller(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestMetrics.Do(registerMetrics)
+	upsStatusTransitions.Reset()
+	start := time.Unix(1590000000, 0).UTC()
+	p.pollAt(start)
+	if err = s.Set("ups", "ups.status", "OB DISCHRG"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set("ups", "battery.charge", "95"); err != nil {
+		t.Fatal(err)
+	}
+	p.pollAt(start.Add(time.Minute))
+	activeRecorder, dialNut = nil, dialServer
+	var live bytes.Buffer
+	if err = writeNutMetrics(&live); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "> USERNAME ****\n") || !strings.Contains(string(content), "> PASSWORD ****\n") ||
+		strings.Contains(string(content), "monitor") || strings.Contains(string(content), "secret") {
+		t.Errorf("user and password aren't redacted:\n%s", content)
+	}
+
+	sessions, err := loadRecord(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || !sessions[1].time.Equal(start.Add(time.Minute)) {
+		t.Fatalf("expected 2 sessions get %+v", sessions)
+	}
+	// transitions of live polling are counted again by replay
+	upsStatusTransitions.Reset()
+	if err = replaySessions(sessions, base); err != nil {
+		t.Fatal(err)
+	}
+	var replayed bytes.Buffer
+	if err = writeNutMetrics(&replayed); err != nil {
+		t.Fatal(err)
+	}
+	if live.String() != replayed.String() {
+		t.Errorf("replay metrics differ from live metrics: %v", goldenDiff(live.String(), replayed.String()))
+	}
+	for _, expected := range []string{"nut_battery_charge 95", "nut_ups_status 4"} {
+		if !strings.Contains(replayed.String(), expected+"\n") {
+			t.Errorf("replay metrics don't contain %s", expected)
+		}
+	}
+	if strings.Contains(replayed.String(), "nut_ups_mfr") {
+		t.Error("replay doesn't apply filters")
+	}
+}
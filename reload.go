@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: nameSpace,
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload attempt was successful",
+	})
+
+	configReloadSuccessTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: nameSpace,
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Time of the last successful configuration reload (unix timestamp)",
+	})
+
+	configReloadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: nameSpace,
+		Name:      "config_reload_failures_total",
+		Help:      "Number of failed configuration reloads",
+	})
+
+	reloadMutex     sync.Mutex
+	webServerActive *webServer
+)
+
+// startPoller start first poller, configuration must be already loaded and valid
+func startPoller(c *configData) error {
+	p, err := newPoller(c, nil)
+	if err != nil {
+		return err
+	}
+	pollerMutex.Lock()
+	activePoller = p
+	pollerMutex.Unlock()
+	p.start()
+	configReloadSuccess.Set(1)
+	configReloadSuccessTime.Set(float64(time.Now().Unix()))
+	return nil
+}
+
+// reload load and validate new configuration, then replace running poller, on problem old poller keep running
+func reload() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	err := reloadConfig()
+	if err != nil {
+		configReloadSuccess.Set(0)
+		configReloadFailures.Inc()
+		_ = level.Error(logger).Log("msg", "configuration reload failed", "error", err)
+		return err
+	}
+	configReloadSuccess.Set(1)
+	configReloadSuccessTime.Set(float64(time.Now().Unix()))
+	_ = level.Info(logger).Log("msg", "configuration reloaded", "ups", currentConfig().UpsName)
+	return nil
+}
+
+// reloadConfig create poller for new configuration with all outputs, then apply web configuration
+// and replace running poller, running poller isn't changed when any step fails
+func reloadConfig() error {
+	c := newConfig()
+	if err := c.loadFile(*configFile); err != nil {
+		return err
+	}
+	var webConfig *webConfigData
+	if webServerActive != nil && len(*webConfigFile) > 0 {
+		loaded, err := loadWebConfig(*webConfigFile)
+		if err != nil {
+			return err
+		}
+		webConfig = &loaded
+	}
+	old := currentPoller()
+	p, err := newPoller(c, old)
+	if err != nil {
+		return err
+	}
+	if webConfig != nil {
+		if err = webServerActive.reload(*webConfig); err != nil {
+			p.abort(old)
+			return err
+		}
+	}
+	replacePoller(old, p)
+	return nil
+}
+
+// replacePoller swap running poller, old poller stop polling before new one start, so status tracker
+// and reused outputs are never used by both, outputs not reused by new poller are closed at the end
+func replacePoller(old, p *poller) {
+	pollerMutex.Lock()
+	activePoller = p
+	pollerMutex.Unlock()
+	old.stopPolling()
+	if p.hooks != nil && old.hooks != nil {
+		p.hooks.takeOver(old.hooks)
+	}
+	state.retain(p.config.UpsName)
+	p.start()
+	old.closeOutputs(p.reused)
+}
+
+func watchReloadSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			_ = level.Info(logger).Log("msg", "received SIGHUP, reload configuration")
+			_ = reload()
+		}
+	}()
+}
+
+// reloadHandler handle POST /-/reload, endpoint is enabled only with authentication in web configuration
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+	if webServerActive == nil || !webServerActive.authenticated() {
+		writeJSONError(w, http.StatusForbidden, errors.New("reload require basic auth or client certificates in web configuration"))
+		return
+	}
+	if err := reload(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
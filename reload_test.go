@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// waitFor check condition until it is true or timeout expires
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatal("condition isn't true before timeout")
+}
+
+func TestReloadConfig(t *testing.T) {
+	s := startSimulation(t)
+	content := "server: 127.0.0.1\nport: %s\nanonymous: true\nupsName: %s\n"
+	_, port, err := net.SplitHostPort(s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousFile := *configFile
+	*configFile = writeTestFile(t, "nut.yml", fmt.Sprintf(content, port, "ups"))
+	defer func() { *configFile = previousFile }()
+
+	c := newConfig()
+	if err := c.loadFile(*configFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := startPoller(c); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { currentPoller().close() }()
+	waitFor(t, func() bool {
+		snapshot, ok := state.get("ups")
+		return ok && !snapshot.LastSuccess.IsZero()
+	})
+	first := currentPoller()
+
+	// invalid configuration keep running poller
+	if err := ioutil.WriteFile(*configFile, []byte("server: 127.0.0.1\nanonymous: true\nrefresh: 1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(); err == nil {
+		t.Fatal("expected error for invalid configuration")
+	}
+	// poller which can't connect outputs keep running poller
+	if err := ioutil.WriteFile(*configFile, []byte(fmt.Sprintf(content, port, "ups")+"mqtt:\n  broker: tcp://127.0.0.1:1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(); err == nil {
+		t.Fatal("expected error for unreachable MQTT broker")
+	}
+	if currentPoller() != first {
+		t.Fatal("running poller is replaced after failed reload")
+	}
+	select {
+	case <-first.stop:
+		t.Fatal("running poller is stopped after failed reload")
+	default:
+	}
+
+	// new UPS replace state of previous UPS
+	if err := ioutil.WriteFile(*configFile, []byte(fmt.Sprintf(content, port, "other")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(); err != nil {
+		t.Fatal(err)
+	}
+	if currentPoller() == first {
+		t.Fatal("running poller isn't replaced")
+	}
+	select {
+	case <-first.done:
+	default:
+		t.Fatal("previous poller is still polling")
+	}
+	if _, ok := state.get("ups"); ok {
+		t.Error("state of UPS removed from configuration is still exposed")
+	}
+	waitFor(t, func() bool {
+		snapshot, ok := state.get("other")
+		return ok && !snapshot.LastPoll.IsZero()
+	})
+}
+
+func TestHookRunnerTakeOver(t *testing.T) {
+	hooks := []hookConfig{
+		{Flag: "OB", Command: []string{"true"}, Delay: 3600},
+		{Flag: "LB", Command: []string{"true"}, Delay: 3600},
+	}
+	previous := newHookRunner(hooks, "ups")
+	previous.handleEvents([]upsEvent{{Time: time.Now(), Ups: "ups", Type: eventStatus, From: "OL", To: "OB LB"}})
+	if len(previous.timers) != 2 {
+		t.Fatalf("expected 2 pending timers get %d", len(previous.timers))
+	}
+	runner := newHookRunner(hooks[:1], "ups")
+	runner.takeOver(previous)
+	defer runner.close()
+	if len(previous.timers) != 0 {
+		t.Errorf("previous runner keep %d timers", len(previous.timers))
+	}
+	if _, ok := runner.timers[0]; !ok || len(runner.timers) != 1 {
+		t.Fatalf("timer of same hook isn't taken over: %v", runner.timers)
+	}
+	if remaining := time.Until(runner.deadlines[0]); remaining > time.Hour || remaining < 59*time.Minute {
+		t.Errorf("timer doesn't keep remaining delay: %s", remaining)
+	}
+}
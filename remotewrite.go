@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// remote write protocol https://prometheus.io/docs/concepts/remote_write_spec/
+
+type remoteWriteConfig struct {
+	URL         string            `yaml:"url" json:"url"`
+	User        string            `yaml:"user" json:"user"`
+	Password    string            `yaml:"password" json:"password"`
+	BearerToken string            `yaml:"bearerToken" json:"bearerToken"`
+	Labels      map[string]string `yaml:"labels" json:"labels"`
+	QueueSize   int               `yaml:"queueSize" json:"queueSize"`
+	Retries     int               `yaml:"retries" json:"retries"`
+	Timeout     int               `yaml:"timeout" json:"timeout"`
+}
+
+type remoteSample struct {
+	labels map[string]string
+	value  float64
+}
+
+type remoteWriter struct {
+	config   remoteWriteConfig
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	queue    chan []byte
+	done     chan struct{}
+}
+
+func (r *remoteWriteConfig) enabled() bool {
+	return len(r.URL) > 0
+}
+
+func (r *remoteWriteConfig) validate() []error {
+	if !r.enabled() {
+		return nil
+	}
+	var errs []error
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		errs = append(errs, errors.New("remote write URL must be valid http:// or https:// URL"))
+	}
+	if len(r.BearerToken) > 0 && len(r.User) > 0 {
+		errs = append(errs, errors.New("remote write can use only one of basic auth or bearer token"))
+	}
+	if r.QueueSize < 0 || r.QueueSize > 10000 {
+		errs = append(errs, errors.New("remote write queue size is out of range (0-10000)"))
+	}
+	if r.Retries < 0 || r.Retries > 10 {
+		errs = append(errs, errors.New("remote write retries is out of range (0-10)"))
+	}
+	if r.Timeout < 0 || r.Timeout > 60 {
+		errs = append(errs, errors.New("remote write timeout is out of range (0-60 sec)"))
+	}
+	return errs
+}
+
+func newRemoteWriter(c remoteWriteConfig, gatherer prometheus.Gatherer) *remoteWriter {
+	if c.QueueSize == 0 {
+		c.QueueSize = 100
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10
+	}
+	if c.Labels == nil {
+		c.Labels = map[string]string{}
+	}
+	if _, ok := c.Labels["job"]; !ok {
+		c.Labels["job"] = applicationName
+	}
+	writer := &remoteWriter{
+		config:   c,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: time.Duration(c.Timeout) * time.Second},
+		queue:    make(chan []byte, c.QueueSize),
+		done:     make(chan struct{}),
+	}
+	go writer.run()
+	return writer
+}
+
+// handleData gather actual metrics and put them to queue, when queue is full the oldest request is dropped,
+// data after close are ignored
+func (w *remoteWriter) handleData(_ string, now time.Time) {
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		_ = level.Warn(logger).Log("msg", "problem gather metrics for remote write", "error", err)
+	}
+	request := encodeWriteRequest(w.samples(families), now)
+	for {
+		select {
+		case w.queue <- request:
+			return
+		default:
+		}
+		select {
+		case <-w.queue:
+			_ = level.Warn(logger).Log("msg", "remote write queue is full, drop oldest request")
+		default:
+		}
+	}
+}
+
+// run send requests from queue until writer is closed, queue is never closed so late handleData can't panic
+func (w *remoteWriter) run() {
+	for {
+		var request []byte
+		select {
+		case <-w.done:
+			return
+		case request = <-w.queue:
+		}
+		var err error
+		backoff := time.Second
+		for attempt := 0; ; attempt++ {
+			if err = w.send(request); err == nil {
+				break
+			}
+			if attempt >= w.config.Retries {
+				break
+			}
+			select {
+			case <-w.done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err != nil {
+			_ = level.Error(logger).Log("msg", "problem push metrics with remote write", "url", w.config.URL, "error", err)
+			continue
+		}
+		_ = level.Debug(logger).Log("msg", "metrics pushed with remote write", "url", w.config.URL)
+	}
+}
+
+func (w *remoteWriter) send(request []byte) error {
+	httpRequest, err := http.NewRequest(http.MethodPost, w.config.URL, bytes.NewReader(snappy.Encode(nil, request)))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Encoding", "snappy")
+	httpRequest.Header.Set("Content-Type", "application/x-protobuf")
+	httpRequest.Header.Set("User-Agent", applicationName+"/"+Version)
+	httpRequest.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if len(w.config.BearerToken) > 0 {
+		httpRequest.Header.Set("Authorization", "Bearer "+w.config.BearerToken)
+	} else if len(w.config.User) > 0 {
+		httpRequest.SetBasicAuth(w.config.User, w.config.Password)
+	}
+	response, err := w.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("server returned status %s", response.Status)
+	}
+	return nil
+}
+
+// samples convert metric families to samples, histograms and summaries are split to series like in exposition format
+func (w *remoteWriter) samples(families []*dto.MetricFamily) []remoteSample {
+	var samples []remoteSample
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for name, value := range w.config.Labels {
+				labels[name] = value
+			}
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			add := func(suffix string, value float64, extra ...string) {
+				series := map[string]string{"__name__": family.GetName() + suffix}
+				for name, v := range labels {
+					series[name] = v
+				}
+				for i := 0; i+1 < len(extra); i += 2 {
+					series[extra[i]] = extra[i+1]
+				}
+				samples = append(samples, remoteSample{series, value})
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", metric.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				for _, bucket := range histogram.GetBucket() {
+					add("_bucket", float64(bucket.GetCumulativeCount()), "le", formatFloat(bucket.GetUpperBound()))
+				}
+				add("_bucket", float64(histogram.GetSampleCount()), "le", "+Inf")
+				add("_sum", histogram.GetSampleSum())
+				add("_count", float64(histogram.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					add("", quantile.GetValue(), "quantile", formatFloat(quantile.GetQuantile()))
+				}
+				add("_sum", summary.GetSampleSum())
+				add("_count", float64(summary.GetSampleCount()))
+			}
+		}
+	}
+	return samples
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// encodeWriteRequest create protobuf prometheus.WriteRequest, labels in each series are sorted by name
+func encodeWriteRequest(samples []remoteSample, now time.Time) []byte {
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+	var request []byte
+	for _, sample := range samples {
+		var names []string
+		for name := range sample.labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var series []byte
+		for _, name := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, sample.labels[name])
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var value []byte
+		value = protowire.AppendTag(value, 1, protowire.Fixed64Type)
+		value = protowire.AppendFixed64(value, math.Float64bits(sample.value))
+		value = protowire.AppendTag(value, 2, protowire.VarintType)
+		value = protowire.AppendVarint(value, uint64(timestamp))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, value)
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+	return request
+}
+
+// close stop sending, requests in queue are dropped
+func (w *remoteWriter) close() {
+	select {
+	case <-w.done:
+	default:
+		close(w.done)
+	}
+}
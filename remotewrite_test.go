@@ -0,0 +1,160 @@
+package main
+
+import (
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// prompb schema https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto
+//  WriteRequest { repeated TimeSeries timeseries = 1; }
+//  TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//  Label { string name = 1; string value = 2; }
+//  Sample { double value = 1; int64 timestamp = 2; }
+
+type decodedSample struct {
+	value     float64
+	timestamp int64
+}
+
+type decodedSeries struct {
+	labels  [][2]string
+	samples []decodedSample
+}
+
+// decodeFields call field for every field in message, only types used by prompb are supported
+func decodeFields(t *testing.T, data []byte, field func(number protowire.Number, value []byte, number64 uint64)) {
+	t.Helper()
+	for len(data) > 0 {
+		number, kind, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			t.Fatalf("invalid tag: %s", protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch kind {
+		case protowire.BytesType:
+			value, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				t.Fatalf("invalid bytes of field %d: %s", number, protowire.ParseError(n))
+			}
+			field(number, value, 0)
+			data = data[n:]
+		case protowire.VarintType:
+			value, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				t.Fatalf("invalid varint of field %d: %s", number, protowire.ParseError(n))
+			}
+			field(number, nil, value)
+			data = data[n:]
+		case protowire.Fixed64Type:
+			value, n := protowire.ConsumeFixed64(data)
+			if n < 0 {
+				t.Fatalf("invalid fixed64 of field %d: %s", number, protowire.ParseError(n))
+			}
+			field(number, nil, value)
+			data = data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d of field %d", kind, number)
+		}
+	}
+}
+
+func decodeWriteRequest(t *testing.T, data []byte) []decodedSeries {
+	t.Helper()
+	var result []decodedSeries
+	decodeFields(t, data, func(number protowire.Number, value []byte, _ uint64) {
+		if number != 1 {
+			t.Fatalf("unexpected field %d in WriteRequest", number)
+		}
+		var series decodedSeries
+		decodeFields(t, value, func(number protowire.Number, value []byte, _ uint64) {
+			switch number {
+			case 1:
+				var label [2]string
+				decodeFields(t, value, func(number protowire.Number, value []byte, _ uint64) {
+					label[number-1] = string(value)
+				})
+				series.labels = append(series.labels, label)
+			case 2:
+				var sample decodedSample
+				decodeFields(t, value, func(number protowire.Number, _ []byte, value uint64) {
+					switch number {
+					case 1:
+						sample.value = math.Float64frombits(value)
+					case 2:
+						sample.timestamp = int64(value)
+					}
+				})
+				series.samples = append(series.samples, sample)
+			default:
+				t.Fatalf("unexpected field %d in TimeSeries", number)
+			}
+		})
+		result = append(result, series)
+	})
+	return result
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	now := time.Unix(1590000000, 123000000)
+	samples := []remoteSample{
+		{labels: map[string]string{"__name__": "nut_ups_load", "job": "nut_exporter", "ups": "ups"}, value: 12.5},
+		{labels: map[string]string{"__name__": "nut_battery_charge"}, value: 100},
+	}
+	expected := []decodedSeries{
+		{
+			labels:  [][2]string{{"__name__", "nut_ups_load"}, {"job", "nut_exporter"}, {"ups", "ups"}},
+			samples: []decodedSample{{12.5, 1590000000123}},
+		},
+		{
+			labels:  [][2]string{{"__name__", "nut_battery_charge"}},
+			samples: []decodedSample{{100, 1590000000123}},
+		},
+	}
+	if series := decodeWriteRequest(t, encodeWriteRequest(samples, now)); !reflect.DeepEqual(series, expected) {
+		t.Errorf("expected %+v get %+v", expected, series)
+	}
+}
+
+func TestRemoteWriterSend(t *testing.T) {
+	received := make(chan []decodedSeries, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, body)
+		if err != nil {
+			t.Errorf("body isn't snappy encoded: %s", err)
+		}
+		received <- decodeWriteRequest(t, data)
+	}))
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: nameSpace + "_test_value", Help: "test"})
+	registry.MustRegister(gauge)
+	gauge.Set(42)
+	writer := newRemoteWriter(remoteWriteConfig{URL: server.URL}, registry)
+	writer.handleData("", time.Now())
+	select {
+	case series := <-received:
+		if len(series) != 1 || series[0].labels[0] != [2]string{"__name__", nameSpace + "_test_value"} ||
+			series[0].labels[1] != [2]string{"job", applicationName} || series[0].samples[0].value != 42 {
+			t.Errorf("unexpected series %+v", series)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request isn't sent")
+	}
+	writer.close()
+	// data after close are ignored
+	writer.handleData("", time.Now())
+	writer.close()
+}
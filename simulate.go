@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/go-kit/kit/log/level"
+	"github.com/pokornyIt/nut_exporter/nutsim"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var (
+	simulateCommand = kingpin.Command("simulate", "Run fake NUT server with UPS defined in YAML file, for tests and demos.")
+	simulateFile    = simulateCommand.Arg("file", "File with simulated UPS definitions").Required().ExistingFile()
+	simulateListen  = simulateCommand.Flag("listen", "Address where fake NUT server listen, overwrite address from file").PlaceHolder("127.0.0.1:3493").Default("").String()
+)
+
+// simulate run fake NUT server until SIGINT or SIGTERM
+func simulate() int {
+	c, err := nutsim.LoadConfig(*simulateFile)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "problem with simulation file", "file", *simulateFile, "error", err)
+		return 1
+	}
+	server := nutsim.NewServer(c)
+	if err = server.Start(*simulateListen); err != nil {
+		_ = level.Error(logger).Log("msg", "problem start fake NUT server", "error", err)
+		return 1
+	}
+	_ = level.Info(logger).Log("msg", "fake NUT server started", "address", server.Addr(), "ups", len(c.Ups))
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	_ = level.Info(logger).Log("msg", "stop fake NUT server")
+	_ = server.Close()
+	return 0
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type upsSnapshot struct {
+	Name        string            `json:"name"`
+	Server      string            `json:"server"`
+	Variables   map[string]string `json:"variables"`
+	Flags       []string          `json:"flags"`
+	LastPoll    time.Time         `json:"lastPoll"`
+	LastSuccess time.Time         `json:"lastSuccess"`
+	Error       string            `json:"error"`
+	Failures    int               `json:"consecutiveFailures"`
+}
+
+type upsSummary struct {
+	Name        string    `json:"name"`
+	Server      string    `json:"server"`
+	Flags       []string  `json:"flags"`
+	LastPoll    time.Time `json:"lastPoll"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	Error       string    `json:"error"`
+}
+
+type stateStore struct {
+	ups   map[string]*upsSnapshot
+	mutex sync.RWMutex
+}
+
+var state = newStateStore()
+
+func newStateStore() *stateStore {
+	return &stateStore{ups: map[string]*upsSnapshot{}}
+}
+
+func (s *stateStore) snapshot(name, server string) *upsSnapshot {
+	snapshot, ok := s.ups[name]
+	if !ok {
+		snapshot = &upsSnapshot{Name: name, Server: server, Variables: map[string]string{}, Flags: []string{}}
+		s.ups[name] = snapshot
+	}
+	return snapshot
+}
+
+func (s *stateStore) register(name, server string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.snapshot(name, server)
+}
+
+// retain remove state of UPS which aren't in configuration
+func (s *stateStore) retain(names ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keep := map[string]bool{}
+	for _, name := range names {
+		keep[name] = true
+	}
+	for name := range s.ups {
+		if !keep[name] {
+			delete(s.ups, name)
+		}
+	}
+}
+
+// update store result of poll, on error previous variables are kept
+func (s *stateStore) update(name, server, output string, err error, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	snapshot := s.snapshot(name, server)
+	snapshot.LastPoll = now
+	if err != nil {
+		snapshot.Error = err.Error()
+		snapshot.Failures++
+		return
+	}
+	snapshot.Error = ""
+	snapshot.Failures = 0
+	snapshot.LastSuccess = now
+	snapshot.Variables = parseVarList(output)
+	snapshot.Flags = strings.Fields(snapshot.Variables["ups.status"])
+}
+
+func (s *stateStore) get(name string) (upsSnapshot, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	snapshot, ok := s.ups[name]
+	if !ok {
+		return upsSnapshot{}, false
+	}
+	copied := *snapshot
+	copied.Variables = map[string]string{}
+	for key, value := range snapshot.Variables {
+		copied.Variables[key] = value
+	}
+	return copied, true
+}
+
+func (s *stateStore) list() []upsSummary {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	list := []upsSummary{}
+	for _, snapshot := range s.ups {
+		list = append(list, upsSummary{snapshot.Name, snapshot.Server, snapshot.Flags, snapshot.LastPoll, snapshot.LastSuccess, snapshot.Error})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *stateStore) all() []upsSnapshot {
+	var list []upsSnapshot
+	for _, summary := range s.list() {
+		if snapshot, ok := s.get(summary.Name); ok {
+			list = append(list, snapshot)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// upsListHandler handle /api/v1/ups
+func upsListHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, state.list())
+}
+
+// upsHandler handle /api/v1/ups/{name}
+func upsHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/ups/")
+	if len(name) == 0 {
+		upsListHandler(w, r)
+		return
+	}
+	snapshot, ok := state.get(name)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("UPS ["+name+"] isn't monitored"))
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
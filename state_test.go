@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// getJSON call handler and decode JSON response
+func getJSON(t *testing.T, handler http.HandlerFunc, path string, result interface{}) int {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("%s: expected JSON content type get %q", path, contentType)
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(result); err != nil {
+		t.Fatalf("%s: %s", path, err)
+	}
+	return recorder.Code
+}
+
+func TestUpsHandlers(t *testing.T) {
+	s := useTestState(t)
+	now := time.Unix(1590000000, 0).UTC()
+	s.update("ups", "nut:3493", "battery.charge: 100\nups.status: OL CHRG", nil, now)
+	s.update("rack", "nut:3493", "", errors.New("UNKNOWN-UPS"), now)
+
+	var list []upsSummary
+	if code := getJSON(t, upsListHandler, "/api/v1/ups", &list); code != http.StatusOK {
+		t.Errorf("list: unexpected status %d", code)
+	}
+	expected := []upsSummary{
+		{Name: "rack", Server: "nut:3493", Flags: []string{}, LastPoll: now, Error: "UNKNOWN-UPS"},
+		{Name: "ups", Server: "nut:3493", Flags: []string{"OL", "CHRG"}, LastPoll: now, LastSuccess: now},
+	}
+	if !reflect.DeepEqual(list, expected) {
+		t.Errorf("list: expected %+v get %+v", expected, list)
+	}
+
+	var snapshot upsSnapshot
+	if code := getJSON(t, upsHandler, "/api/v1/ups/ups", &snapshot); code != http.StatusOK {
+		t.Errorf("ups: unexpected status %d", code)
+	}
+	if snapshot.Name != "ups" || snapshot.Variables["battery.charge"] != "100" || !reflect.DeepEqual(snapshot.Flags, []string{"OL", "CHRG"}) {
+		t.Errorf("ups: unexpected snapshot %+v", snapshot)
+	}
+
+	var failure map[string]string
+	if code := getJSON(t, upsHandler, "/api/v1/ups/unknown", &failure); code != http.StatusNotFound || failure["error"] != "UPS [unknown] isn't monitored" {
+		t.Errorf("unknown: expected 404 get %d %v", code, failure)
+	}
+
+	list = nil
+	if code := getJSON(t, upsHandler, "/api/v1/ups/", &list); code != http.StatusOK || len(list) != 2 {
+		t.Errorf("ups without name must return list get %d %+v", code, list)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// status flags https://networkupstools.org/docs/developer-guide.chunked/apas02.html
+const (
+	statusOnLine     = "OL"
+	statusOnBattery  = "OB"
+	statusLowBattery = "LB"
+	statusForcedShut = "FSD"
+)
+
+var (
+	upsStatusRegex     = regexp.MustCompile(`(?m)^(?:ups[.]status:(?:\s)(.*))`)
+	upsAlarmRegex      = regexp.MustCompile(`(?m)^(?:ups[.]alarm:(?:\s)(.*))`)
+	upsTestResultRegex = regexp.MustCompile(`(?m)^(?:ups[.]test[.]result:(?:\s)(.*))`)
+
+	upsStatusTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nameSpace,
+		Name:      "ups_status_transitions_total",
+		Help:      "Number of transitions into UPS status flag (OL, OB, LB, ...)",
+	}, []string{"ups", "flag"})
+
+	upsStatusLastChange = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: nameSpace,
+		Name:      "ups_status_last_change_timestamp_seconds",
+		Help:      "Time of last change of UPS status flags (unix timestamp)",
+	}, []string{"ups"})
+
+	upsOnBatteryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: nameSpace,
+		Name:      "ups_on_battery_duration_seconds",
+		Help:      "Duration of finished episodes when UPS runs on battery (seconds)",
+		Buckets:   []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200},
+	}, []string{"ups"})
+)
+
+type statusTracker struct {
+	upsName        string
+	flags          map[string]bool
+	status         string
+	alarm          string
+	testResult     string
+	onBatterySince time.Time
+	started        bool
+	restored       map[string]bool // event types with state restored from event log
+}
+
+func newStatusTracker(upsName string) *statusTracker {
+	return &statusTracker{upsName: upsName}
+}
+
+func parseStatusFlags(value string) map[string]bool {
+	flags := map[string]bool{}
+	for _, flag := range strings.Fields(value) {
+		flags[flag] = true
+	}
+	return flags
+}
+
+func readValue(analyzer *regexp.Regexp, output string) (string, bool) {
+	found := analyzer.FindStringSubmatch(output)
+	if found == nil {
+		return "", false
+	}
+	return found[1], true
+}
+
+// restore set state from last events stored before start, first poll then create events for changes
+// made while exporter didn't run
+func (t *statusTracker) restore(last map[string]upsEvent) {
+	t.restored = map[string]bool{}
+	if event, ok := last[eventStatus]; ok {
+		t.status = event.To
+		t.flags = parseStatusFlags(event.To)
+		if t.flags[statusOnBattery] {
+			t.onBatterySince = event.Time
+		}
+		upsStatusLastChange.WithLabelValues(t.upsName).Set(float64(event.Time.UnixNano()) / 1e9)
+		t.restored[eventStatus] = true
+	}
+	if event, ok := last[eventAlarm]; ok {
+		t.alarm = event.To
+		t.restored[eventAlarm] = true
+	}
+	if event, ok := last[eventTest]; ok {
+		t.testResult = event.To
+		t.restored[eventTest] = true
+	}
+}
+
+// update compare actual data with data from previous poll and return found events, first poll only set baseline
+// of values which aren't restored from event log
+func (t *statusTracker) update(output string, now time.Time) []upsEvent {
+	var events []upsEvent
+	if !t.started {
+		t.started = true
+		if !t.restored[eventStatus] {
+			t.status, _ = readValue(upsStatusRegex, output)
+			t.updateStatus(t.status, now)
+		}
+		if !t.restored[eventAlarm] {
+			t.alarm, _ = readValue(upsAlarmRegex, output)
+		}
+		if !t.restored[eventTest] {
+			t.testResult, _ = readValue(upsTestResultRegex, output)
+		}
+	}
+	if status, ok := readValue(upsStatusRegex, output); ok {
+		if t.updateStatus(status, now) {
+			events = append(events, upsEvent{now, t.upsName, eventStatus, t.status, status})
+		}
+		t.status = status
+	}
+	alarm, _ := readValue(upsAlarmRegex, output)
+	if alarm != t.alarm {
+		events = append(events, upsEvent{now, t.upsName, eventAlarm, t.alarm, alarm})
+		t.alarm = alarm
+	}
+	testResult, _ := readValue(upsTestResultRegex, output)
+	if testResult != t.testResult {
+		events = append(events, upsEvent{now, t.upsName, eventTest, t.testResult, testResult})
+		t.testResult = testResult
+	}
+	return events
+}
+
+func (t *statusTracker) updateStatus(value string, now time.Time) bool {
+	flags := parseStatusFlags(value)
+	if t.flags == nil {
+		t.flags = flags
+		if flags[statusOnBattery] {
+			t.onBatterySince = now
+		}
+		return false
+	}
+	changed := len(flags) != len(t.flags)
+	for flag := range flags {
+		if !t.flags[flag] {
+			upsStatusTransitions.WithLabelValues(t.upsName, flag).Inc()
+			changed = true
+		}
+	}
+	if changed {
+		upsStatusLastChange.WithLabelValues(t.upsName).Set(float64(now.UnixNano()) / 1e9)
+	}
+	if flags[statusOnBattery] && !t.flags[statusOnBattery] {
+		t.onBatterySince = now
+	}
+	if !flags[statusOnBattery] && t.flags[statusOnBattery] && !t.onBatterySince.IsZero() {
+		upsOnBatteryDuration.WithLabelValues(t.upsName).Observe(now.Sub(t.onBatterySince).Seconds())
+		t.onBatterySince = time.Time{}
+	}
+	t.flags = flags
+	return changed
+}
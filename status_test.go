@@ -0,0 +1,114 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+	"time"
+)
+
+// onBatteryEpisodes return count and sum of on battery histogram
+func onBatteryEpisodes(t *testing.T) (uint64, float64) {
+	t.Helper()
+	metric := &dto.Metric{}
+	if err := upsOnBatteryDuration.WithLabelValues("ups").(prometheus.Histogram).Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+func TestStatusTrackerTransitions(t *testing.T) {
+	start := time.Unix(1590000000, 0).UTC()
+	tracker := newStatusTracker("ups")
+	onBattery := testutil.ToFloat64(upsStatusTransitions.WithLabelValues("ups", "OB"))
+	lowBattery := testutil.ToFloat64(upsStatusTransitions.WithLabelValues("ups", "LB"))
+	count, sum := onBatteryEpisodes(t)
+
+	steps := []struct {
+		output string
+		events []upsEvent
+	}{
+		{output: "ups.status: OL CHRG\nups.alarm: Replace battery!"},
+		{output: "ups.status: OL CHRG\nups.alarm: Replace battery!"},
+		{
+			output: "ups.status: OB DISCHRG\nups.alarm: Replace battery!",
+			events: []upsEvent{{Ups: "ups", Type: eventStatus, From: "OL CHRG", To: "OB DISCHRG"}},
+		},
+		{
+			output: "ups.status: OB DISCHRG LB\nups.test.result: Aborted",
+			events: []upsEvent{
+				{Ups: "ups", Type: eventStatus, From: "OB DISCHRG", To: "OB DISCHRG LB"},
+				{Ups: "ups", Type: eventAlarm, From: "Replace battery!", To: ""},
+				{Ups: "ups", Type: eventTest, From: "", To: "Aborted"},
+			},
+		},
+		{
+			output: "ups.status: OL CHRG\nups.test.result: Aborted",
+			events: []upsEvent{{Ups: "ups", Type: eventStatus, From: "OB DISCHRG LB", To: "OL CHRG"}},
+		},
+		// flags in other order aren't change
+		{output: "ups.status: CHRG OL\nups.test.result: Aborted"},
+	}
+	for i, step := range steps {
+		now := start.Add(time.Duration(i) * time.Minute)
+		events := tracker.update(step.output, now)
+		if len(events) != len(step.events) {
+			t.Fatalf("step %d: expected %v get %v", i, step.events, events)
+		}
+		for j, event := range events {
+			expected := step.events[j]
+			expected.Time = now
+			if event != expected {
+				t.Errorf("step %d: expected %+v get %+v", i, expected, event)
+			}
+		}
+	}
+
+	if delta := testutil.ToFloat64(upsStatusTransitions.WithLabelValues("ups", "OB")) - onBattery; delta != 1 {
+		t.Errorf("expected 1 transition to OB get %v", delta)
+	}
+	if delta := testutil.ToFloat64(upsStatusTransitions.WithLabelValues("ups", "LB")) - lowBattery; delta != 1 {
+		t.Errorf("expected 1 transition to LB get %v", delta)
+	}
+	if last := testutil.ToFloat64(upsStatusLastChange.WithLabelValues("ups")); last != float64(start.Add(4*time.Minute).Unix()) {
+		t.Errorf("expected last change at step 4 get %v", last)
+	}
+	newCount, newSum := onBatteryEpisodes(t)
+	if newCount-count != 1 || newSum-sum != 120 {
+		t.Errorf("expected one on battery episode of 120 sec get %d episodes of %v sec", newCount-count, newSum-sum)
+	}
+}
+
+// UPS on battery at start has episode from first poll
+func TestStatusTrackerStartOnBattery(t *testing.T) {
+	start := time.Unix(1590000000, 0).UTC()
+	tracker := newStatusTracker("ups")
+	count, sum := onBatteryEpisodes(t)
+	if events := tracker.update("ups.status: OB", start); len(events) != 0 {
+		t.Errorf("first poll must be baseline get %v", events)
+	}
+	tracker.update("ups.status: OL", start.Add(30*time.Second))
+	newCount, newSum := onBatteryEpisodes(t)
+	if newCount-count != 1 || newSum-sum != 30 {
+		t.Errorf("expected one on battery episode of 30 sec get %d episodes of %v sec", newCount-count, newSum-sum)
+	}
+}
+
+// status metrics of each UPS have own series
+func TestStatusTrackerUpsLabel(t *testing.T) {
+	start := time.Unix(1590000000, 0).UTC()
+	other := testutil.ToFloat64(upsStatusTransitions.WithLabelValues("ups", "OB"))
+	tracker := newStatusTracker("rack")
+	tracker.update("ups.status: OL", start)
+	tracker.update("ups.status: OB", start.Add(time.Minute))
+	if count := testutil.ToFloat64(upsStatusTransitions.WithLabelValues("rack", "OB")); count != 1 {
+		t.Errorf("expected 1 transition of rack to OB get %v", count)
+	}
+	if count := testutil.ToFloat64(upsStatusTransitions.WithLabelValues("ups", "OB")); count != other {
+		t.Errorf("transition of rack is counted for other UPS")
+	}
+	if last := testutil.ToFloat64(upsStatusLastChange.WithLabelValues("rack")); last != float64(start.Add(time.Minute).Unix()) {
+		t.Errorf("expected last change of rack at %v get %v", start.Add(time.Minute).Unix(), last)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"github.com/go-kit/kit/log/level"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+var statusFlagNames = map[string]string{
+	"OL":      "Online",
+	"OB":      "On battery",
+	"LB":      "Low battery",
+	"HB":      "High battery",
+	"RB":      "Replace battery",
+	"CHRG":    "Charging",
+	"DISCHRG": "Discharging",
+	"BYPASS":  "On bypass",
+	"CAL":     "Calibration",
+	"OFF":     "Offline",
+	"OVER":    "Overloaded",
+	"TRIM":    "Trimming",
+	"BOOST":   "Boosting",
+	"FSD":     "Forced shutdown",
+}
+
+const statusPageTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{ .Refresh }}">
+<title>NUT Exporter</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+.flag { display: inline-block; padding: 0.1em 0.5em; margin-right: 0.3em; border-radius: 0.3em; background: #ddd; }
+.flag-OL { background: #b6e3b6; }
+.flag-OB, .flag-LB, .flag-FSD, .flag-RB, .flag-OVER { background: #f3b3b3; }
+.ok { color: #2a7a2a; }
+.error { color: #b02020; }
+</style>
+</head>
+<body>
+<h1>NUT Exporter</h1>
+<p>Version {{ .Version }} &middot; <a href="/metrics">Metrics</a> &middot; <a href="/api/v1/ups">API</a></p>
+{{ range .Ups }}
+<h2>{{ .Name }}</h2>
+<table>
+<tr><th>Server</th><td>{{ .Server }}</td></tr>
+<tr><th>Model</th><td>{{ index .Variables "ups.mfr" }} {{ index .Variables "ups.model" }}</td></tr>
+<tr><th>Status</th><td>{{ range .Flags }}<span class="flag flag-{{ . }}" title="{{ . }}">{{ flagName . }}</span>{{ else }}unknown{{ end }}</td></tr>
+<tr><th>Battery charge</th><td>{{ with index .Variables "battery.charge" }}{{ . }} %{{ else }}-{{ end }}</td></tr>
+<tr><th>Load</th><td>{{ with index .Variables "ups.load" }}{{ . }} %{{ else }}-{{ end }}</td></tr>
+<tr><th>Runtime</th><td>{{ with index .Variables "battery.runtime" }}{{ runtime . }}{{ else }}-{{ end }}</td></tr>
+<tr><th>Last poll</th><td>{{ timestamp .LastPoll }}</td></tr>
+<tr><th>Last success</th><td>{{ timestamp .LastSuccess }}</td></tr>
+<tr><th>Connection</th><td>{{ if .Error }}<span class="error">{{ .Error }}</span>{{ else if .LastSuccess.IsZero }}waiting for first poll{{ else }}<span class="ok">OK</span>{{ end }}</td></tr>
+</table>
+{{ end }}
+</body>
+</html>
+`
+
+var statusPage = template.Must(template.New("status").Funcs(template.FuncMap{
+	"flagName": func(flag string) string {
+		if name, ok := statusFlagNames[flag]; ok {
+			return name
+		}
+		return flag
+	},
+	"timestamp": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Format("2006-01-02 15:04:05 MST")
+	},
+	"runtime": func(value string) string {
+		seconds, err := time.ParseDuration(value + "s")
+		if err != nil {
+			return value
+		}
+		return seconds.String()
+	},
+}).Parse(statusPageTemplate))
+
+// statusPageHandler show overview of all UPS on /
+func statusPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	data := struct {
+		Version string
+		Refresh int
+		Ups     []upsSnapshot
+	}{Version, currentConfig().Refresh, state.all()}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusPage.Execute(w, data); err != nil {
+		_ = level.Error(logger).Log("msg", "problem render status page", "error", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusPage(t *testing.T) {
+	s := useTestState(t)
+	now := time.Unix(1590000000, 0).UTC()
+	s.update("ups", "nut:3493", "ups.mfr: APC <Smart>\nups.model: SMT1500\nups.status: OB LB\nbattery.charge: 35\nups.load: 42\nbattery.runtime: 600", nil, now)
+	s.update("rack", "other:3493", "", errors.New("ACCESS-DENIED"), now)
+	recorder := httptest.NewRecorder()
+	statusPageHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("unexpected response %d %v", recorder.Code, recorder.Header())
+	}
+	page := recorder.Body.String()
+	for _, expected := range []string{
+		"<h2>ups</h2>",
+		"<td>nut:3493</td>",
+		"APC &lt;Smart&gt; SMT1500",
+		`<span class="flag flag-OB" title="OB">On battery</span>`,
+		`<span class="flag flag-LB" title="LB">Low battery</span>`,
+		"<td>35 %</td>",
+		"<td>42 %</td>",
+		"<td>10m0s</td>",
+		"2020-05-20 18:40:00 UTC",
+		"<h2>rack</h2>",
+		`<span class="error">ACCESS-DENIED</span>`,
+		"<td>never</td>",
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("status page doesn't contain %s", expected)
+		}
+	}
+	if strings.Index(page, "<h2>rack</h2>") > strings.Index(page, "<h2>ups</h2>") {
+		t.Error("UPS aren't sorted by name")
+	}
+}
+
+func TestStatusPageNotFound(t *testing.T) {
+	useTestState(t)
+	for _, path := range []string{"/metric", "/api/v1/unknown", "/index.html"} {
+		recorder := httptest.NewRecorder()
+		statusPageHandler(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusNotFound || strings.Contains(recorder.Body.String(), "NUT Exporter") {
+			t.Errorf("%s: expected 404 get %d", path, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/go-kit/kit/log/level"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// web configuration file compatible with https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md
+
+// authCacheSize is maximum number of cached successful basic auth checks
+const authCacheSize = 100
+
+var webConfigFile = kingpin.Flag("web.config.file", "Path to configuration file that can enable TLS or authentication.").PlaceHolder("web.yml").Default("").String()
+
+type tlsServerConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	MinVersion     string `yaml:"min_version"`
+}
+
+type webConfigData struct {
+	TLSServerConfig tlsServerConfig   `yaml:"tls_server_config"`
+	BasicAuthUsers  map[string]string `yaml:"basic_auth_users"`
+}
+
+type webServer struct {
+	config    webConfigData
+	tlsConfig *tls.Config
+	modified  time.Time
+	cache     map[[32]byte]struct{} // successful basic auth checks
+	mutex     sync.Mutex
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"TLS10": tls.VersionTLS10,
+		"TLS11": tls.VersionTLS11,
+		"TLS12": tls.VersionTLS12,
+		"TLS13": tls.VersionTLS13,
+	}
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		"":                           tls.NoClientCert,
+		"NoClientCert":               tls.NoClientCert,
+		"RequestClientCert":          tls.RequestClientCert,
+		"RequireAnyClientCert":       tls.RequireAnyClientCert,
+		"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+		"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+	}
+)
+
+func loadWebConfig(filename string) (webConfigData, error) {
+	var c webConfigData
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return c, err
+	}
+	if err = unmarshalStrict(content, &c); err != nil {
+		return c, err
+	}
+	return c, c.validate()
+}
+
+func (c *webConfigData) tlsEnabled() bool {
+	return len(c.TLSServerConfig.CertFile) > 0 || len(c.TLSServerConfig.KeyFile) > 0
+}
+
+func (c *webConfigData) validate() error {
+	t := c.TLSServerConfig
+	if c.tlsEnabled() && (len(t.CertFile) == 0 || len(t.KeyFile) == 0) {
+		return errors.New("TLS certificate and key file must be defined together")
+	}
+	if !c.tlsEnabled() && (len(t.ClientCAFile) > 0 || len(t.ClientAuthType) > 0 || len(t.MinVersion) > 0) {
+		return errors.New("TLS options require certificate and key file")
+	}
+	if _, ok := clientAuthTypes[t.ClientAuthType]; !ok {
+		return errors.New("TLS client auth type [" + t.ClientAuthType + "] isn't valid")
+	}
+	if _, ok := tlsVersions[t.MinVersion]; !ok && len(t.MinVersion) > 0 {
+		return errors.New("TLS min version [" + t.MinVersion + "] isn't valid (TLS10, TLS11, TLS12, TLS13)")
+	}
+	if len(t.ClientCAFile) > 0 && clientAuthTypes[t.ClientAuthType] == tls.NoClientCert {
+		return errors.New("TLS client CA file require client auth type")
+	}
+	// without client CA file certificates are verified against system roots, certificate of any public CA would be valid
+	if len(t.ClientCAFile) == 0 && (clientAuthTypes[t.ClientAuthType] == tls.RequireAndVerifyClientCert || clientAuthTypes[t.ClientAuthType] == tls.VerifyClientCertIfGiven) {
+		return errors.New("TLS client auth type [" + t.ClientAuthType + "] require client CA file")
+	}
+	for name, hash := range c.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return errors.New("password for user [" + name + "] isn't bcrypt hash")
+		}
+	}
+	return nil
+}
+
+func newWebServer(c webConfigData) (*webServer, error) {
+	server := &webServer{config: c, cache: map[[32]byte]struct{}{}}
+	if c.tlsEnabled() {
+		if err := server.loadTLS(); err != nil {
+			return nil, err
+		}
+	}
+	return server, nil
+}
+
+func fileModified(names ...string) time.Time {
+	var modified time.Time
+	for _, name := range names {
+		if len(name) == 0 {
+			continue
+		}
+		if info, err := os.Stat(name); err == nil && info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified
+}
+
+func (s *webServer) loadTLS() error {
+	t := s.config.TLSServerConfig
+	modified := fileModified(t.CertFile, t.KeyFile, t.ClientCAFile)
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuthTypes[t.ClientAuthType],
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(t.MinVersion) > 0 {
+		tlsConfig.MinVersion = tlsVersions[t.MinVersion]
+	}
+	if len(t.ClientCAFile) > 0 {
+		content, err := ioutil.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(content) {
+			return errors.New("no valid certificate in client CA file " + t.ClientCAFile)
+		}
+	}
+	s.tlsConfig = tlsConfig
+	s.modified = modified
+	return nil
+}
+
+// getConfigForClient reload certificates when some file change, on problem previous certificates are used
+func (s *webServer) getConfigForClient(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t := s.config.TLSServerConfig
+	if fileModified(t.CertFile, t.KeyFile, t.ClientCAFile).After(s.modified) {
+		if err := s.loadTLS(); err != nil {
+			_ = level.Error(logger).Log("msg", "problem reload TLS certificates", "error", err)
+		} else {
+			_ = level.Info(logger).Log("msg", "TLS certificates reloaded", "cert", t.CertFile)
+		}
+	}
+	return s.tlsConfig, nil
+}
+
+func (s *webServer) authorized(user, password string) bool {
+	s.mutex.Lock()
+	hash, ok := s.config.BasicAuthUsers[user]
+	s.mutex.Unlock()
+	if !ok {
+		// compare with dummy hash so unknown user take same time
+		hash = "$2a$10$gpYWsFjq/8AHjcllRPUZrup/.YZkV4If2AS4ViDeJQO/PlRh109Nu"
+	}
+	key := sha256.Sum256([]byte(user + ":" + password + ":" + hash))
+	s.mutex.Lock()
+	_, cached := s.cache[key]
+	s.mutex.Unlock()
+	if cached {
+		return true
+	}
+	// failed checks aren't cached, so they always take time of bcrypt and can't fill memory
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || !ok {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.cache) >= authCacheSize {
+		// remove more entries at once, so it isn't done after every check
+		removed := 0
+		for k := range s.cache {
+			if removed >= authCacheSize/10 {
+				break
+			}
+			delete(s.cache, k)
+			removed++
+		}
+	}
+	s.cache[key] = struct{}{}
+	return true
+}
+
+// reload replace users and TLS options, switch between HTTP and HTTPS require restart
+func (s *webServer) reload(c webConfigData) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c.tlsEnabled() != s.config.tlsEnabled() {
+		return errors.New("enable or disable TLS require restart")
+	}
+	previous := s.config
+	s.config = c
+	s.cache = map[[32]byte]struct{}{}
+	if c.tlsEnabled() {
+		if err := s.loadTLS(); err != nil {
+			s.config = previous
+			return err
+		}
+	}
+	return nil
+}
+
+// authenticated is true when clients must use basic auth or verified certificate
+func (s *webServer) authenticated() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.config.BasicAuthUsers) > 0 || clientAuthTypes[s.config.TLSServerConfig.ClientAuthType] == tls.RequireAndVerifyClientCert
+}
+
+func (s *webServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	users := len(s.config.BasicAuthUsers)
+	s.mutex.Unlock()
+	if users > 0 {
+		user, password, ok := r.BasicAuth()
+		if !ok || !s.authorized(user, password) {
+			w.Header().Set("WWW-Authenticate", "Basic realm=\""+applicationName+"\"")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+	http.DefaultServeMux.ServeHTTP(w, r)
+}
+
+// listen start HTTP server, with TLS when certificate is defined
+func (s *webServer) listen(address string) error {
+	server := &http.Server{Addr: address, Handler: s}
+	if !s.config.tlsEnabled() {
+		return server.ListenAndServe()
+	}
+	server.TLSConfig = &tls.Config{GetConfigForClient: s.getConfigForClient}
+	return server.ListenAndServeTLS("", "")
+}
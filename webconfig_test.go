@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"golang.org/x/crypto/bcrypt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testBasicAuthServer(t *testing.T) *webServer {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := newWebServer(webConfigData{BasicAuthUsers: map[string]string{"admin": string(hash)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestWebServerAuthorized(t *testing.T) {
+	s := testBasicAuthServer(t)
+	tests := []struct {
+		user, password string
+		authorized     bool
+	}{
+		{"admin", "secret", true},
+		{"admin", "secret", true},
+		{"admin", "wrong", false},
+		{"unknown", "secret", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		if authorized := s.authorized(test.user, test.password); authorized != test.authorized {
+			t.Errorf("%s:%s expected %v", test.user, test.password, test.authorized)
+		}
+	}
+	if len(s.cache) != 1 {
+		t.Errorf("only successful check must be cached get %d entries", len(s.cache))
+	}
+}
+
+func TestWebServerAuthCacheSize(t *testing.T) {
+	s := testBasicAuthServer(t)
+	for i := 0; i < authCacheSize; i++ {
+		s.cache[[32]byte{byte(i), byte(i >> 8)}] = struct{}{}
+	}
+	if !s.authorized("admin", "secret") {
+		t.Fatal("user isn't authorized with full cache")
+	}
+	if len(s.cache) > authCacheSize {
+		t.Errorf("cache has %d entries, maximum is %d", len(s.cache), authCacheSize)
+	}
+	if !s.authorized("admin", "secret") || len(s.cache) > authCacheSize {
+		t.Error("successful check isn't cached after cleanup")
+	}
+}
+
+// writeTestCertificate create self-signed certificate and key files
+func writeTestCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := writeTestFile(t, "cert.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	keyFile := writeTestFile(t, "key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})))
+	return certFile, keyFile
+}
+
+// certificate is provided by GetConfigForClient only
+func TestWebServerTLSHandshake(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	s, err := newWebServer(webConfigData{TLSServerConfig: tlsServerConfig{CertFile: certFile, KeyFile: keyFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	result := make(chan error, 1)
+	go func() {
+		result <- tls.Server(serverConn, &tls.Config{GetConfigForClient: s.getConfigForClient}).Handshake()
+	}()
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	if err = client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-result; err != nil {
+		t.Fatal(err)
+	}
+	if name := client.ConnectionState().PeerCertificates[0].Subject.CommonName; name != "localhost" {
+		t.Errorf("unexpected certificate %s", name)
+	}
+}
+
+func TestWebConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config tlsServerConfig
+		err    string
+	}{
+		{name: "TLS", config: tlsServerConfig{CertFile: "cert.pem", KeyFile: "key.pem"}},
+		{name: "verified client", config: tlsServerConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuthType: "RequireAndVerifyClientCert", ClientCAFile: "ca.pem"}},
+		{name: "any client certificate", config: tlsServerConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuthType: "RequireAnyClientCert"}},
+		{name: "verify without CA", config: tlsServerConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuthType: "RequireAndVerifyClientCert"}, err: "require client CA file"},
+		{name: "verify if given without CA", config: tlsServerConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuthType: "VerifyClientCertIfGiven"}, err: "require client CA file"},
+		{name: "CA without auth type", config: tlsServerConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientCAFile: "ca.pem"}, err: "require client auth type"},
+		{name: "certificate without key", config: tlsServerConfig{CertFile: "cert.pem"}, err: "must be defined together"},
+	}
+	for _, test := range tests {
+		c := webConfigData{TLSServerConfig: test.config}
+		err := c.validate()
+		if (err == nil) != (len(test.err) == 0) || (err != nil && !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected error %q get %v", test.name, test.err, err)
+		}
+	}
+}